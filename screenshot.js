@@ -2,10 +2,149 @@ var page = require('webpage').create();
 var system = require('system');
 var url = system.args[1];
 var output = system.args[2];
+var options = {};
+var EXIT_NO_MATCH = 2;
+var WAIT_INTERVAL = 100;
+var NETWORK_IDLE = 500;
+var MAX_WAIT_DEFAULT = 10000;
+var pendingRequests = 0;
+var lastNetworkActivity = Date.now();
 
-page.open(url, function() {
-    page.render(output);
+system.args.slice(5).forEach(function(arg) {
+    var equalIdx = arg.indexOf('=');
+    if (-1 < equalIdx) {
+        options[arg.substring(0, equalIdx)] = arg.substring(equalIdx + 1);
+    }
+});
+
+var width = parseInt(options.Width, 10) || page.viewportSize.width;
+var height = parseInt(options.Height, 10) || page.viewportSize.height;
+page.viewportSize = { width: width, height: height };
+
+if ('0' === options.FullPage) {
+    page.clipRect = { top: 0, left: 0, width: width, height: height };
+}
+
+var renderOptions = { format: options.Format || 'png' };
+if (options.Quality) {
+    renderOptions.quality = options.Quality;
+}
+
+if ('pdf' === options.Format) {
+    page.paperSize = {
+        format: options.PaperSize || 'A4',
+        orientation: options.Orientation || 'portrait',
+        margin: '1cm'
+    };
+}
+
+if (options.Clip) {
+    var clip = options.Clip.split(',');
+    page.clipRect = {
+        left: parseInt(clip[0], 10),
+        top: parseInt(clip[1], 10),
+        width: parseInt(clip[2], 10),
+        height: parseInt(clip[3], 10)
+    };
+}
+
+page.onResourceRequested = function() {
+    pendingRequests++;
+    lastNetworkActivity = Date.now();
+};
+
+page.onResourceReceived = function(response) {
+    if ('end' === response.stage) {
+        pendingRequests = Math.max(0, pendingRequests - 1);
+        lastNetworkActivity = Date.now();
+    }
+};
+
+page.onResourceError = function() {
+    pendingRequests = Math.max(0, pendingRequests - 1);
+    lastNetworkActivity = Date.now();
+};
+
+function isWaitConditionMet() {
+    if (options.WaitForSelector) {
+        var found = page.evaluate(function(selector) {
+            return null !== document.querySelector(selector);
+        }, options.WaitForSelector);
+
+        if (!found) {
+            return false;
+        }
+    }
+
+    if ('1' === options.WaitForNetworkIdle) {
+        if (0 < pendingRequests || NETWORK_IDLE > (Date.now() - lastNetworkActivity)) {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+function waitForCondition(callback) {
+    var maxWait = parseInt(options.MaxWaitMs, 10) || MAX_WAIT_DEFAULT;
+    var start = Date.now();
+
+    var timer = setInterval(function() {
+        if (isWaitConditionMet()) {
+            clearInterval(timer);
+            callback('met');
+        } else if (maxWait <= (Date.now() - start)) {
+            clearInterval(timer);
+            callback('timeout');
+        }
+    }, WAIT_INTERVAL);
+}
+
+function capture() {
+    if (options.Selector) {
+        var rect = page.evaluate(function(selector) {
+            var element = document.querySelector(selector);
+            if (!element) {
+                return null;
+            }
+
+            var bound = element.getBoundingClientRect();
+            return {
+                left: bound.left + window.scrollX,
+                top: bound.top + window.scrollY,
+                width: bound.width,
+                height: bound.height
+            };
+        }, options.Selector);
+
+        if (!rect || 0 >= rect.width || 0 >= rect.height) {
+            page.close();
+            phantom.exit(EXIT_NO_MATCH);
+            return;
+        }
+        page.clipRect = rect;
+    }
+
+    page.render(output, renderOptions);
     page.close();
     phantom.exit();
-});
+}
 
+// Name=Value lines printed to stdout are recorded by puppeteer as result
+// metadata of the job.
+page.open(url, function() {
+    setTimeout(function() {
+        if (!options.WaitForSelector && '1' !== options.WaitForNetworkIdle) {
+            if (options.DelayMs) {
+                console.log('WaitOutcome=met');
+            }
+            capture();
+            return;
+        }
+
+        waitForCondition(function(outcome) {
+            console.log('WaitOutcome=' + outcome);
+            capture();
+        });
+    }, parseInt(options.DelayMs, 10) || 0);
+});
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	ppnotify "puppeteerlib/notify"
+	ppstrutil "puppeteerlib/strutil"
+	"regexp"
+	"strconv"
+)
+
+const (
+	ALERT_URI_PREFIX     = "/alert/"
+	POST_PARAM_KEY       = "key"
+	POST_PARAM_WEBHOOK   = "webhook"
+	POST_PARAM_SECRET    = "secret"
+	POST_PARAM_CHANGE_TH = "changeThreshold"
+)
+
+// PuppeteerWebAPIAlert is an alert as told by the API, without its secret.
+type PuppeteerWebAPIAlert struct {
+	Key        string
+	Webhook    string
+	Signed     bool
+	Threshold  float64
+	ScheduleID string
+	CreateTime int64
+}
+
+var gAlertRegexp = regexp.MustCompile("^" + ALERT_URI_PREFIX + "([a-f0-9]{32}\\.[\\d]+)?$")
+
+// ServeAlert serves the /alert/ API: GET lists the alerts or tells one,
+// POST creates or replaces the alert of a key and DELETE removes one.
+func ServeAlert(rsp http.ResponseWriter, req *http.Request) {
+	matchList := gAlertRegexp.FindStringSubmatch(req.URL.Path)
+	if nil == matchList {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := matchList[1]
+	queueDir := gPuppeteerConf.QueueDir
+	apiResponse := PuppeteerWebAPIResponse{RetCode: API_RET_OK}
+
+	if "GET" == req.Method && "" == key {
+		alertList := make([]PuppeteerWebAPIAlert, 0)
+		for _, alert := range ppnotify.ListAlerts(queueDir) {
+			alertList = append(alertList, NewAPIAlert(alert))
+		}
+		apiResponse.Data = alertList
+	} else if "GET" == req.Method {
+		alert := ppnotify.ReadAlert(queueDir, key)
+		if nil == alert {
+			rsp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		apiResponse.Data = NewAPIAlert(alert)
+	} else if "POST" == req.Method && "" == key {
+		alert := GetAlert(req, req.FormValue(POST_PARAM_KEY))
+		if nil == alert {
+			rsp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !ppnotify.WriteAlert(queueDir, alert) {
+			apiResponse.RetCode = API_RET_ERR_IO
+			apiResponse.RetMsg = API_RET_ERR_IO_MSG
+		}
+		apiResponse.Data = NewAPIAlert(alert)
+	} else if "DELETE" == req.Method && "" != key {
+		if !ppnotify.RemoveAlert(queueDir, key) {
+			rsp.WriteHeader(http.StatusNotFound)
+			return
+		}
+	} else {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	jsonBytes, _ := json.Marshal(apiResponse)
+	rsp.Header().Set("Content-Type", "application/json")
+	io.WriteString(rsp, string(jsonBytes))
+}
+
+// GetAlert returns an alert of key built from the POST parameters webhook,
+// and optionally changeThreshold and secret. It returns nil if the
+// parameters are invalid.
+func GetAlert(req *http.Request, key string) *ppnotify.Alert {
+	webhook := req.FormValue(POST_PARAM_WEBHOOK)
+	if !ppstrutil.IsValidURL(webhook) {
+		return nil
+	}
+
+	threshold := float64(0)
+	if thresholdStr := req.FormValue(POST_PARAM_CHANGE_TH); "" != thresholdStr {
+		var err error
+		if threshold, err = strconv.ParseFloat(thresholdStr, 64); nil != err {
+			return nil
+		}
+	}
+
+	return ppnotify.NewAlert(key, webhook, req.FormValue(POST_PARAM_SECRET), threshold)
+}
+
+func NewAPIAlert(alert *ppnotify.Alert) PuppeteerWebAPIAlert {
+	return PuppeteerWebAPIAlert{
+		Key:        alert.Key,
+		Webhook:    alert.Webhook,
+		Signed:     "" != alert.Secret || "" != gPuppeteerConf.WebhookSecret,
+		Threshold:  alert.Threshold,
+		ScheduleID: alert.ScheduleID,
+		CreateTime: alert.CreateTime}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	ppbatch "puppeteerlib/batch"
+	pppool "puppeteerlib/pool"
+	ppstrutil "puppeteerlib/strutil"
+	"regexp"
+	"strconv"
+)
+
+const (
+	BATCH_URI        = "/batch"
+	BATCH_URI_PREFIX = "/batch/"
+	BATCH_BODY_MAX   = 16 << 20 //16M
+	BATCH_ITEM_MAX   = 10000
+	GET_PARAM_ITEMS  = "items"
+	BATCH_ERR_ITEM   = "invalid item"
+	BATCH_ERR_PARAM  = "invalid parameters"
+)
+
+type PuppeteerWebAPIBatch struct {
+	ID       string
+	Count    int
+	ErrorCnt int
+	Items    []PuppeteerWebAPIBatchItem
+}
+
+type PuppeteerWebAPIBatchItem struct {
+	Index  int
+	Key    string `json:",omitempty"`
+	Status uint8
+	Error  string `json:",omitempty"`
+}
+
+// PuppeteerWebAPIBatchProgress counts the items of a batch by the status
+// of their screenshots. Items which could not be queued are ErrorCnt, Done
+// are those which will not change any more.
+type PuppeteerWebAPIBatchProgress struct {
+	ID         string
+	CreateTime int64
+	Count      int
+	ErrorCnt   int
+	Queued     int
+	Running    int
+	Ready      int
+	Failed     int
+	NoMatch    int
+	Cancelled  int
+	Done       int
+	Items      []PuppeteerWebAPIBatchItem `json:",omitempty"`
+}
+
+var gBatchRegexp = regexp.MustCompile("^" + BATCH_URI + "(/([a-f0-9]{32})?)?$")
+
+// ServeBatch serves the /batch API: POST queues the jobs of a batch and
+// tells the key or the error of each item, GET /batch/{id} tells the
+// progress of a batch, and of each item with items=true.
+func ServeBatch(rsp http.ResponseWriter, req *http.Request) {
+	matchList := gBatchRegexp.FindStringSubmatch(req.URL.Path)
+	if nil == matchList {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	batchID := matchList[2]
+	apiResponse := PuppeteerWebAPIResponse{RetCode: API_RET_OK}
+
+	if "POST" == req.Method && "" == batchID {
+		req.Body = http.MaxBytesReader(rsp, req.Body, BATCH_BODY_MAX)
+		rawItemList, err := ReadBatchItems(req.Body)
+		if nil != err {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				rsp.WriteHeader(http.StatusRequestEntityTooLarge)
+			} else {
+				rsp.WriteHeader(http.StatusBadRequest)
+			}
+			return
+		}
+
+		apiResponse = SubmitBatch(req, rawItemList)
+	} else if "GET" == req.Method && "" != batchID {
+		showItems, err := strconv.ParseBool(req.FormValue(GET_PARAM_ITEMS))
+		if nil != err && "" != req.FormValue(GET_PARAM_ITEMS) {
+			rsp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		apiProgress := GetBatchProgress(batchID, showItems)
+		if nil == apiProgress {
+			rsp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		apiResponse.Data = apiProgress
+	} else {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	jsonBytes, _ := json.Marshal(apiResponse)
+	rsp.Header().Set("Content-Type", "application/json")
+	io.WriteString(rsp, string(jsonBytes))
+}
+
+// ReadBatchItems returns the items of a batch, given either as a JSON array
+// or as a stream of JSON values, one per line. It fails if the body is not
+// valid JSON, or has none or more than BATCH_ITEM_MAX items.
+func ReadBatchItems(body io.Reader) ([]json.RawMessage, error) {
+	ret := make([]json.RawMessage, 0)
+	decoder := json.NewDecoder(body)
+
+	for {
+		var rawItem json.RawMessage
+		err := decoder.Decode(&rawItem)
+		if io.EOF == err {
+			break
+		} else if nil != err {
+			return nil, err
+		}
+
+		if '[' != rawItem[0] {
+			ret = append(ret, rawItem)
+		} else if 0 == len(ret) && !decoder.More() {
+			if err := json.Unmarshal(rawItem, &ret); nil != err {
+				return nil, err
+			}
+		} else {
+			return nil, errors.New("array mixed with items")
+		}
+
+		if BATCH_ITEM_MAX < len(ret) {
+			return nil, errors.New("too many items")
+		}
+	}
+
+	if 0 == len(ret) {
+		return nil, errors.New("no items")
+	}
+
+	return ret, nil
+}
+
+// GetBatchItemRequest returns a request carrying the fields of a batch item
+// as its form, so that the item is validated as POST /info/ is. The query
+// parameters of the batch apply to all items, unless an item gives them.
+func GetBatchItemRequest(req *http.Request, rawItem json.RawMessage) *http.Request {
+	decoder := json.NewDecoder(bytes.NewReader(rawItem))
+	decoder.UseNumber()
+
+	var itemInfo map[string]interface{}
+	if err := decoder.Decode(&itemInfo); nil != err || nil == itemInfo {
+		return nil
+	}
+
+	itemForm := url.Values{}
+	for paramName, paramList := range req.URL.Query() {
+		itemForm[paramName] = paramList
+	}
+
+	for paramName, paramVal := range itemInfo {
+		switch paramVal := paramVal.(type) {
+		case string:
+			itemForm.Set(paramName, paramVal)
+		case json.Number:
+			itemForm.Set(paramName, paramVal.String())
+		case bool:
+			itemForm.Set(paramName, strconv.FormatBool(paramVal))
+		case nil:
+			itemForm.Del(paramName)
+		default:
+			return nil
+		}
+	}
+
+	return &http.Request{Method: "POST", URL: req.URL, Header: req.Header, Form: itemForm}
+}
+
+// SubmitBatch queues the jobs of the batch items and keeps the batch for
+// GET /batch/{id}. An invalid item does not keep the others from being
+// queued, its error is told instead of its key.
+func SubmitBatch(req *http.Request, rawItemList []json.RawMessage) PuppeteerWebAPIResponse {
+	apiResponse := PuppeteerWebAPIResponse{}
+	batchID := ppbatch.NewID()
+	if "" == batchID {
+		apiResponse.RetCode = API_RET_ERR_IO
+		apiResponse.RetMsg = API_RET_ERR_IO_MSG
+		return apiResponse
+	}
+
+	apiBatch := PuppeteerWebAPIBatch{ID: batchID,
+		Count: len(rawItemList),
+		Items: make([]PuppeteerWebAPIBatchItem, 0, len(rawItemList))}
+	itemList := make([]ppbatch.BatchItem, 0, len(rawItemList))
+
+	for index, rawItem := range rawItemList {
+		apiItem := SubmitBatchItem(req, rawItem)
+		apiItem.Index = index
+		if "" != apiItem.Error {
+			apiBatch.ErrorCnt++
+		}
+
+		apiBatch.Items = append(apiBatch.Items, apiItem)
+		itemList = append(itemList, ppbatch.BatchItem{Key: apiItem.Key, Error: apiItem.Error})
+	}
+
+	apiResponse.RetCode = API_RET_OK
+	if !ppbatch.WriteBatch(gPuppeteerConf.QueueDir, batchID, itemList) {
+		// the jobs are queued anyway, only the progress can not be told.
+		apiResponse.RetCode = API_RET_ERR_IO
+		apiResponse.RetMsg = API_RET_ERR_IO_MSG
+		apiBatch.ID = ""
+	}
+	apiResponse.Data = apiBatch
+
+	return apiResponse
+}
+
+// SubmitBatchItem queues the job of a batch item as POST /info/ does, the
+// user agent of the batch request is used if the item gives none.
+func SubmitBatchItem(req *http.Request, rawItem json.RawMessage) PuppeteerWebAPIBatchItem {
+	itemReq := GetBatchItemRequest(req, rawItem)
+	if nil == itemReq {
+		return PuppeteerWebAPIBatchItem{Status: pppool.STAT_ERR, Error: BATCH_ERR_ITEM}
+	}
+
+	targetURL := itemReq.FormValue(POST_PARAM_URL)
+	userAgent := itemReq.FormValue(POST_PARAM_UAGENT)
+	if "" == userAgent {
+		userAgent = req.UserAgent()
+	}
+
+	jobOptions, optionsOk := GetJobOptions(itemReq)
+	jobControl, controlOk := GetJobControl(itemReq)
+	if "" == targetURL || "" == userAgent || !ppstrutil.IsValidURL(targetURL) || !optionsOk || !controlOk {
+		return PuppeteerWebAPIBatchItem{Status: pppool.STAT_ERR, Error: BATCH_ERR_PARAM}
+	}
+
+	ret := PuppeteerWebAPIBatchItem{Status: pppool.STAT_ERR}
+	apiResponse := SubmitJob(targetURL, userAgent, jobOptions, jobControl)
+	if apiInfo, infoOk := apiResponse.Data.(PuppeteerWebAPIInfo); infoOk {
+		ret.Key = apiInfo.Key
+		ret.Status = apiInfo.Status
+	}
+	if API_RET_OK != apiResponse.RetCode || "" == ret.Key {
+		ret.Error = API_RET_ERR_IO_MSG
+	}
+
+	return ret
+}
+
+// GetBatchProgress returns the progress of the batch of id, or nil if there
+// is no such batch. The items are told only if showItems.
+func GetBatchProgress(batchID string, showItems bool) *PuppeteerWebAPIBatchProgress {
+	itemList, createTime := ppbatch.ReadBatch(gPuppeteerConf.QueueDir, batchID)
+	if nil == itemList {
+		return nil
+	}
+
+	ret := &PuppeteerWebAPIBatchProgress{ID: batchID,
+		CreateTime: createTime,
+		Count:      len(itemList)}
+	if showItems {
+		ret.Items = make([]PuppeteerWebAPIBatchItem, 0, len(itemList))
+	}
+
+	for index, item := range itemList {
+		apiItem := PuppeteerWebAPIBatchItem{Index: index, Key: item.Key, Status: pppool.STAT_ERR, Error: item.Error}
+		if "" != item.Error {
+			ret.ErrorCnt++
+			ret.Done++
+		} else if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, item.Key); nil != screenshotInfo {
+			apiItem.Status = screenshotInfo.Status
+			switch screenshotInfo.Status {
+			case pppool.STAT_QUEUED:
+				ret.Queued++
+			case pppool.STAT_RUNNING:
+				ret.Running++
+			case pppool.STAT_READY, pppool.STAT_EXPIRED:
+				ret.Ready++
+				ret.Done++
+			case pppool.STAT_FAILED:
+				ret.Failed++
+				ret.Done++
+			case pppool.STAT_NO_MATCH:
+				ret.NoMatch++
+				ret.Done++
+			case pppool.STAT_CANCELLED:
+				ret.Cancelled++
+				ret.Done++
+			}
+		}
+
+		if showItems {
+			ret.Items = append(ret.Items, apiItem)
+		}
+	}
+
+	return ret
+}
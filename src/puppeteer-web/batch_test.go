@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadBatchItems(t *testing.T) {
+	caseList := []struct {
+		name     string
+		body     string
+		itemList []string
+	}{
+		{"array", `[{"url":"a"}, {"url":"b"}]`, []string{`{"url":"a"}`, `{"url":"b"}`}},
+		{"array with blanks", "\n [ {\"url\":\"a\"} ]\n", []string{`{"url":"a"}`}},
+		{"array of any values", `[1, null, "x"]`, []string{`1`, `null`, `"x"`}},
+		{"ndjson", "{\"url\":\"a\"}\n{\"url\":\"b\"}\n", []string{`{"url":"a"}`, `{"url":"b"}`}},
+		{"ndjson with blank lines", "\n{\"url\":\"a\"}\n\n{\"url\":\"b\"}", []string{`{"url":"a"}`, `{"url":"b"}`}},
+		{"single item", `{"url":"a"}`, []string{`{"url":"a"}`}},
+		{"empty body", "", nil},
+		{"empty array", "[]", nil},
+		{"array after item", "{\"url\":\"a\"}\n[{\"url\":\"b\"}]", nil},
+		{"item after array", "[{\"url\":\"a\"}]\n{\"url\":\"b\"}", nil},
+		{"two arrays", "[{\"url\":\"a\"}]\n[{\"url\":\"b\"}]", nil},
+		{"truncated item", "{\"url\":\"a\"}\n{\"url\":", nil},
+		{"truncated array", `[{"url":"a"},`, nil},
+		{"not json", "url=a", nil},
+	}
+
+	for _, c := range caseList {
+		rawItemList, err := ReadBatchItems(strings.NewReader(c.body))
+		if nil == c.itemList {
+			if nil == err {
+				t.Errorf("%s: no error", c.name)
+			}
+			continue
+		}
+
+		if nil != err {
+			t.Errorf("%s: error %v", c.name, err)
+			continue
+		}
+
+		if len(c.itemList) != len(rawItemList) {
+			t.Errorf("%s: %d items, want %d", c.name, len(rawItemList), len(c.itemList))
+			continue
+		}
+		for idx, rawItem := range rawItemList {
+			if c.itemList[idx] != string(rawItem) {
+				t.Errorf("%s: item %d is %s, want %s", c.name, idx, rawItem, c.itemList[idx])
+			}
+		}
+	}
+}
+
+func TestReadBatchItemsMax(t *testing.T) {
+	body := strings.Repeat("{}\n", BATCH_ITEM_MAX)
+	if rawItemList, err := ReadBatchItems(strings.NewReader(body)); nil != err || BATCH_ITEM_MAX != len(rawItemList) {
+		t.Errorf("%d items: error %v", BATCH_ITEM_MAX, err)
+	}
+
+	if _, err := ReadBatchItems(strings.NewReader(body + "{}\n")); nil == err {
+		t.Errorf("%d items: no error", BATCH_ITEM_MAX+1)
+	}
+
+	body = "[" + strings.Repeat("{},", BATCH_ITEM_MAX) + "{}]"
+	if _, err := ReadBatchItems(strings.NewReader(body)); nil == err {
+		t.Errorf("array of %d items: no error", BATCH_ITEM_MAX+1)
+	}
+}
+
+func TestGetBatchItemRequest(t *testing.T) {
+	req := httptest.NewRequest("POST", BATCH_URI+"?width=640&userAgent=q&height=480", nil)
+
+	itemReq := GetBatchItemRequest(req, json.RawMessage(`{"url":"http://a.com/","width":800,"fullPage":false,"quality":0.5,"userAgent":null}`))
+	if nil == itemReq {
+		t.Fatal("valid item rejected")
+	}
+	for paramName, paramVal := range map[string]string{
+		POST_PARAM_URL:      "http://a.com/",
+		POST_PARAM_WIDTH:    "800",
+		POST_PARAM_HEIGHT:   "480",
+		POST_PARAM_FULLPAGE: "false",
+		POST_PARAM_QUALITY:  "0.5",
+		POST_PARAM_UAGENT:   ""} {
+		if paramVal != itemReq.FormValue(paramName) {
+			t.Errorf("%s is %q, want %q", paramName, itemReq.FormValue(paramName), paramVal)
+		}
+	}
+
+	for _, rawItem := range []string{`1`, `null`, `"http://a.com/"`, `[{"url":"http://a.com/"}]`, `{"url":{"href":"http://a.com/"}}`, `{"url":["http://a.com/"]}`} {
+		if nil != GetBatchItemRequest(req, json.RawMessage(rawItem)) {
+			t.Errorf("invalid item %s accepted", rawItem)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	pppool "puppeteerlib/pool"
+	ppqueue "puppeteerlib/queue"
+	ppstrutil "puppeteerlib/strutil"
+	"time"
+)
+
+const (
+	CAPTURE_URI = "/capture"
+)
+
+// ServeCapture serves GET and POST /capture, which takes the parameters of
+// POST /info/ and answers with the screenshot itself. A fresh screenshot is
+// served at once, otherwise a job is queued and waited for up to wait, or
+// CaptureTimeout of the configuration. If the job does not complete in
+// time, the response is 202 with the JSON of POST /info/, and 502 if it
+// fails.
+func ServeCapture(rsp http.ResponseWriter, req *http.Request) {
+	if "GET" != req.Method && "POST" != req.Method {
+		rsp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	targetURL := req.FormValue(POST_PARAM_URL)
+	// image tags can not pass a user agent, the one of the client is used.
+	userAgent := req.FormValue(POST_PARAM_UAGENT)
+	if "" == userAgent {
+		userAgent = req.UserAgent()
+	}
+
+	jobOptions, optionsOk := GetJobOptions(req)
+	jobControl, controlOk := GetJobControl(req)
+	wait, waitOk := GetWait(req)
+	if "" == req.FormValue(GET_PARAM_WAIT) {
+		wait = time.Duration(gPuppeteerConf.CaptureTimeout) * time.Second
+	}
+
+	if "" == targetURL || "" == userAgent || !ppstrutil.IsValidURL(targetURL) || !optionsOk || !controlOk || !waitOk {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	apiResponse := SubmitJob(targetURL, userAgent, jobOptions, jobControl)
+	apiInfo, infoOk := apiResponse.Data.(PuppeteerWebAPIInfo)
+	if API_RET_OK != apiResponse.RetCode || !infoOk {
+		WriteCaptureResponse(rsp, http.StatusInternalServerError, apiResponse)
+		return
+	}
+
+	screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, apiInfo.Key)
+	if nil == screenshotInfo {
+		WriteCaptureResponse(rsp, http.StatusInternalServerError, apiResponse)
+		return
+	}
+
+	// a fresh screenshot is ready at once, it is not waited for.
+	if pppool.STAT_READY != apiInfo.Status {
+		if screenshotInfo = WaitScreenshot(rsp, req, screenshotInfo, wait); nil == screenshotInfo {
+			return
+		}
+	}
+	pppool.ApplyExpire(screenshotInfo, ppqueue.GetJobMaxAge(jobControl, gPuppeteerConf.Expire))
+	apiResponse.Data = NewAPIInfo(screenshotInfo)
+
+	switch screenshotInfo.Status {
+	case pppool.STAT_READY, pppool.STAT_EXPIRED:
+		if ServeScreenshot(rsp, screenshotInfo, pppool.GetScreenshotFilePath(screenshotInfo), screenshotInfo.LastUpdate, screenshotInfo.Stale) {
+			pppool.TouchScreenshot(screenshotInfo)
+		}
+	case pppool.STAT_QUEUED, pppool.STAT_RUNNING:
+		rsp.Header().Set("Location", PIC_URI_PREFIX+screenshotInfo.Fingerprint)
+		WriteCaptureResponse(rsp, http.StatusAccepted, apiResponse)
+	default:
+		WriteCaptureResponse(rsp, http.StatusBadGateway, apiResponse)
+	}
+}
+
+func WriteCaptureResponse(rsp http.ResponseWriter, statusCode int, apiResponse PuppeteerWebAPIResponse) {
+	jsonBytes, _ := json.Marshal(apiResponse)
+
+	rsp.Header().Set("Content-Type", "application/json")
+	rsp.WriteHeader(statusCode)
+	io.WriteString(rsp, string(jsonBytes))
+}
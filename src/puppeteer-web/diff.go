@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"io"
+	"net/http"
+	ppdiff "puppeteerlib/diff"
+	pppool "puppeteerlib/pool"
+	"strconv"
+	"time"
+)
+
+const (
+	DIFF_URI_PREFIX     = "/diff/"
+	GET_PARAM_FROM      = "from"
+	GET_PARAM_TO        = "to"
+	GET_PARAM_THRESHOLD = "threshold"
+	DIFF_THRESHOLD_MAX  = 255
+)
+
+type PuppeteerWebAPIDiff struct {
+	Key  string
+	From int64
+	To   int64
+	*ppdiff.DiffResult
+}
+
+// GetDiffVersions returns the versions to compare from the GET parameters.
+// to defaults to the current screenshot, from to the version before to.
+// Either version is 0 if there is no such version.
+func GetDiffVersions(req *http.Request, versionList []pppool.ScreenshotVersion) (int64, int64, bool) {
+	from, to := int64(0), int64(0)
+
+	if toStr := req.FormValue(GET_PARAM_TO); "" != toStr {
+		version, err := strconv.ParseInt(toStr, 10, 64)
+		if nil != err {
+			return 0, 0, false
+		}
+		for _, screenshotVersion := range versionList {
+			if version == screenshotVersion.Version {
+				to = version
+			}
+		}
+	} else if 0 < len(versionList) {
+		to = versionList[0].Version
+	}
+
+	if fromStr := req.FormValue(GET_PARAM_FROM); "" != fromStr {
+		version, err := strconv.ParseInt(fromStr, 10, 64)
+		if nil != err {
+			return 0, 0, false
+		}
+		for _, screenshotVersion := range versionList {
+			if version == screenshotVersion.Version {
+				from = version
+			}
+		}
+	} else {
+		// versions are listed newest first.
+		for _, screenshotVersion := range versionList {
+			if screenshotVersion.Version < to {
+				from = screenshotVersion.Version
+				break
+			}
+		}
+	}
+
+	return from, to, true
+}
+
+// ServeDiff compares two versions of the screenshot, and sends the diff
+// image if format=png is given, or the JSON summary of the changes.
+func ServeDiff(rsp http.ResponseWriter, req *http.Request, screenshotInfo *pppool.ScreenshotInfo) {
+	if pppool.FORMAT_PDF == screenshotInfo.Format {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	outputFormat := req.FormValue(POST_PARAM_FORMAT)
+	if "" != outputFormat && "json" != outputFormat && pppool.FORMAT_PNG != outputFormat {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	threshold := ppdiff.THRESHOLD_DEFAULT
+	if thresholdStr := req.FormValue(GET_PARAM_THRESHOLD); "" != thresholdStr {
+		var err error
+		if threshold, err = strconv.Atoi(thresholdStr); nil != err || 0 > threshold || DIFF_THRESHOLD_MAX < threshold {
+			rsp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	from, to, versionOk := GetDiffVersions(req, pppool.ListScreenshotVersions(screenshotInfo))
+	if !versionOk {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if 0 == from || 0 == to {
+		rsp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	fromImage, fromErr := ppdiff.LoadImage(pppool.GetScreenshotVersionPath(screenshotInfo, from))
+	toImage, toErr := ppdiff.LoadImage(pppool.GetScreenshotVersionPath(screenshotInfo, to))
+	if ppdiff.ErrTooLarge == fromErr || ppdiff.ErrTooLarge == toErr {
+		rsp.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	if nil != fromErr || nil != toErr {
+		// the version may have been removed meanwhile.
+		rsp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	diffResult := ppdiff.Compare(fromImage, toImage, threshold, pppool.FORMAT_PNG == outputFormat)
+	if nil == diffResult {
+		rsp.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if pppool.FORMAT_PNG == outputFormat {
+		rsp.Header().Set("Content-Type", pppool.GetFormatContentType(pppool.FORMAT_PNG))
+		rsp.Header().Set("Content-Disposition", "inline; filename=diff"+pppool.SCREENSHOT_PREFIX)
+		rsp.Header().Set("Last-Modified", time.Unix(to, 0).UTC().Format(http.TimeFormat))
+		png.Encode(rsp, diffResult.Image)
+		return
+	}
+
+	apiDiff := PuppeteerWebAPIDiff{Key: screenshotInfo.Fingerprint, From: from, To: to, DiffResult: diffResult}
+	jsonBytes, _ := json.Marshal(PuppeteerWebAPIResponse{RetCode: API_RET_OK, Data: apiDiff})
+
+	rsp.Header().Set("Content-Type", "application/json")
+	io.WriteString(rsp, string(jsonBytes))
+}
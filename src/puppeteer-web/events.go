@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	ppioutil "puppeteerlib/ioutil"
+	pppool "puppeteerlib/pool"
+	"strconv"
+	"time"
+)
+
+const (
+	EVENTS_URI_PREFIX   = "/events/"
+	GET_PARAM_WAIT      = "wait"
+	INFO_WAIT_MAX       = 60 * time.Second
+	STATE_POLL_INTERVAL = time.Second
+	KEEPALIVE_INTERVAL  = 15 * time.Second
+	SSE_EVENT_STATE     = "state"
+)
+
+var gDirWatcher *ppioutil.DirWatcher
+
+// GetWait returns the wait parameter, how long GET /info/ may block for
+// the job to complete, given as a duration like 30s or as seconds.
+func GetWait(req *http.Request) (time.Duration, bool) {
+	waitStr := req.FormValue(GET_PARAM_WAIT)
+	if "" == waitStr {
+		return 0, true
+	}
+
+	ret, err := time.ParseDuration(waitStr)
+	if nil != err {
+		seconds, parseErr := strconv.ParseInt(waitStr, 10, 64)
+		if nil != parseErr {
+			return 0, false
+		}
+		ret = time.Duration(seconds) * time.Second
+	}
+
+	if 0 > ret || INFO_WAIT_MAX < ret {
+		return 0, false
+	}
+
+	return ret, true
+}
+
+// WatchScreenshot returns a channel which is signalled whenever the state
+// or the files of the screenshot may have changed, and a function to stop
+// watching. The channel is nil if the pool can not be watched, callers then
+// poll every STATE_POLL_INTERVAL.
+func WatchScreenshot(screenshotInfo *pppool.ScreenshotInfo) (chan bool, func()) {
+	if nil == gDirWatcher {
+		return nil, func() {}
+	}
+
+	// the shard does not exist before the first job of the key.
+	watchChannel := gDirWatcher.Subscribe(screenshotInfo.PoolDir)
+	if nil == watchChannel {
+		return nil, func() {}
+	}
+
+	return watchChannel, func() {
+		gDirWatcher.Unsubscribe(screenshotInfo.PoolDir, watchChannel)
+	}
+}
+
+// reloadScreenshot returns the current state of the screenshot, or the
+// given one if it can not be read.
+func reloadScreenshot(screenshotInfo *pppool.ScreenshotInfo) *pppool.ScreenshotInfo {
+	if nextInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, screenshotInfo.Fingerprint); nil != nextInfo {
+		return nextInfo
+	}
+
+	return screenshotInfo
+}
+
+func isJobInflight(screenshotInfo *pppool.ScreenshotInfo) bool {
+	return pppool.STAT_QUEUED == screenshotInfo.Status || pppool.STAT_RUNNING == screenshotInfo.Status
+}
+
+// WaitScreenshot waits up to wait for the queued or running job of the
+// screenshot to complete, and returns the screenshot as it is then. It
+// returns nil if the client went away meanwhile.
+func WaitScreenshot(rsp http.ResponseWriter, req *http.Request, screenshotInfo *pppool.ScreenshotInfo, wait time.Duration) *pppool.ScreenshotInfo {
+	if 0 >= wait || !isJobInflight(screenshotInfo) {
+		return screenshotInfo
+	}
+
+	http.NewResponseController(rsp).SetWriteDeadline(time.Now().Add(wait + gWriteTimeout))
+	watchChannel, stopWatch := WatchScreenshot(screenshotInfo)
+	defer func() {
+		stopWatch()
+	}()
+
+	// the job may have completed before the watch started.
+	screenshotInfo = reloadScreenshot(screenshotInfo)
+
+	waitTimer := time.NewTimer(wait)
+	defer waitTimer.Stop()
+
+	for isJobInflight(screenshotInfo) {
+		var pollChannel <-chan time.Time
+		if nil == watchChannel {
+			pollChannel = time.After(STATE_POLL_INTERVAL)
+		}
+
+		select {
+		case _, ok := <-watchChannel:
+			// the watch is dropped once the shard is removed.
+			if !ok {
+				watchChannel = nil
+			}
+		case <-pollChannel:
+		case <-waitTimer.C:
+			return screenshotInfo
+		case <-req.Context().Done():
+			return nil
+		}
+
+		if nil == watchChannel {
+			stopWatch()
+			watchChannel, stopWatch = WatchScreenshot(screenshotInfo)
+		}
+		screenshotInfo = reloadScreenshot(screenshotInfo)
+	}
+
+	return screenshotInfo
+}
+
+// ServeEvents streams the state of the screenshot as server-sent events,
+// the current state first and then each change, until the client goes
+// away.
+func ServeEvents(rsp http.ResponseWriter, req *http.Request, screenshotInfo *pppool.ScreenshotInfo) {
+	rspController := http.NewResponseController(rsp)
+	rspController.SetWriteDeadline(time.Time{})
+	watchChannel, stopWatch := WatchScreenshot(screenshotInfo)
+	defer func() {
+		stopWatch()
+	}()
+
+	// the state may have changed before the watch started.
+	screenshotInfo = reloadScreenshot(screenshotInfo)
+
+	rsp.Header().Set("Content-Type", "text/event-stream")
+	rsp.Header().Set("Cache-Control", "no-cache")
+	rsp.Header().Set("X-Accel-Buffering", "no")
+
+	keepaliveTicker := time.NewTicker(KEEPALIVE_INTERVAL)
+	defer keepaliveTicker.Stop()
+
+	var lastInfo *PuppeteerWebAPIInfo
+	for {
+		pppool.ApplyExpire(screenshotInfo, gPuppeteerConf.Expire)
+		if apiInfo := NewAPIInfo(screenshotInfo); nil == lastInfo || apiInfo != *lastInfo {
+			jsonBytes, _ := json.Marshal(apiInfo)
+			if _, err := fmt.Fprintf(rsp, "event: %s\ndata: %s\n\n", SSE_EVENT_STATE, jsonBytes); nil != err {
+				return
+			}
+			rspController.Flush()
+			lastInfo = &apiInfo
+		}
+
+		var pollChannel <-chan time.Time
+		if nil == watchChannel {
+			pollChannel = time.After(STATE_POLL_INTERVAL)
+		}
+
+		select {
+		case _, ok := <-watchChannel:
+			// the watch is dropped once the shard is removed.
+			if !ok {
+				watchChannel = nil
+			}
+		case <-pollChannel:
+		case <-keepaliveTicker.C:
+			// comments keep proxies from closing an idle stream.
+			if _, err := fmt.Fprint(rsp, ": keepalive\n\n"); nil != err {
+				return
+			}
+			rspController.Flush()
+		case <-req.Context().Done():
+			return
+		}
+
+		// the shard is created by the first job of the key, and may be
+		// removed and created again later on. The state is read once
+		// watched, so that no change is missed meanwhile.
+		if nil == watchChannel {
+			stopWatch()
+			watchChannel, stopWatch = WatchScreenshot(screenshotInfo)
+		}
+		screenshotInfo = reloadScreenshot(screenshotInfo)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	ppnotify "puppeteerlib/notify"
+	ppschedule "puppeteerlib/schedule"
+	ppstrutil "puppeteerlib/strutil"
+	"regexp"
+	"strconv"
+)
+
+const (
+	SCHEDULE_URI_PREFIX = "/schedule/"
+	POST_PARAM_INTERVAL = "interval"
+	POST_PARAM_CRON     = "cron"
+	POST_PARAM_JITTER   = "jitter"
+	INTERVAL_MAX        = 366 * 86400
+	JITTER_MAX          = 86400
+)
+
+type PuppeteerWebAPISchedule struct {
+	ID         string
+	Key        string
+	URL        string
+	UserAgent  string
+	Interval   int64
+	Cron       string
+	Jitter     int64
+	NextRun    int64
+	LastRun    int64
+	CreateTime int64
+	Options    map[string]string
+	History    []PuppeteerWebAPIScheduleRun `json:",omitempty"`
+}
+
+type PuppeteerWebAPIScheduleRun struct {
+	Time   int64
+	Key    string
+	Result string
+}
+
+var gScheduleRegexp = regexp.MustCompile("^" + SCHEDULE_URI_PREFIX + "([a-f0-9]{32})?$")
+
+// ServeSchedule serves the /schedule/ API: GET lists the schedules or
+// tells one with its history, POST creates a schedule and DELETE removes
+// one.
+func ServeSchedule(rsp http.ResponseWriter, req *http.Request) {
+	matchList := gScheduleRegexp.FindStringSubmatch(req.URL.Path)
+	if nil == matchList {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	scheduleID := matchList[1]
+	queueDir := gPuppeteerConf.QueueDir
+	apiResponse := PuppeteerWebAPIResponse{RetCode: API_RET_OK}
+
+	if "GET" == req.Method && "" == scheduleID {
+		scheduleList := make([]PuppeteerWebAPISchedule, 0)
+		for _, schedule := range ppschedule.ListSchedules(queueDir) {
+			scheduleList = append(scheduleList, NewAPISchedule(schedule))
+		}
+		apiResponse.Data = scheduleList
+	} else if "GET" == req.Method {
+		schedule := ppschedule.ReadSchedule(queueDir, scheduleID)
+		if nil == schedule {
+			rsp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		apiSchedule := NewAPISchedule(schedule)
+		apiSchedule.History = make([]PuppeteerWebAPIScheduleRun, 0)
+		for _, scheduleRun := range ppschedule.ReadHistory(queueDir, scheduleID) {
+			apiSchedule.History = append(apiSchedule.History, PuppeteerWebAPIScheduleRun(scheduleRun))
+		}
+		apiResponse.Data = apiSchedule
+	} else if "POST" == req.Method && "" == scheduleID {
+		schedule := GetSchedule(req)
+		if nil == schedule {
+			rsp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// the changes of the screenshots of the schedule are alerted if a
+		// webhook is given.
+		var alert *ppnotify.Alert
+		if "" != req.FormValue(POST_PARAM_WEBHOOK) {
+			if alert = GetAlert(req, ppstrutil.URLOptions2Fingerprint(schedule.URL, schedule.JobOptions)); nil == alert {
+				rsp.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			alert.ScheduleID = schedule.ID
+		}
+
+		if !ppschedule.WriteSchedule(queueDir, schedule) || (nil != alert && !ppnotify.WriteAlert(queueDir, alert)) {
+			apiResponse.RetCode = API_RET_ERR_IO
+			apiResponse.RetMsg = API_RET_ERR_IO_MSG
+		}
+		apiResponse.Data = NewAPISchedule(schedule)
+	} else if "DELETE" == req.Method && "" != scheduleID {
+		if !ppschedule.RemoveSchedule(queueDir, scheduleID) {
+			rsp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ppnotify.RemoveScheduleAlerts(queueDir, scheduleID)
+	} else {
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	jsonBytes, _ := json.Marshal(apiResponse)
+	rsp.Header().Set("Content-Type", "application/json")
+	io.WriteString(rsp, string(jsonBytes))
+}
+
+// GetSchedule returns a new schedule built from the POST parameters, which
+// are those of POST /info/ plus either interval or cron, and optionally
+// jitter. The alert parameters are left to GetAlert. It returns nil if the parameters are invalid.
+func GetSchedule(req *http.Request) *ppschedule.Schedule {
+	targetURL := req.FormValue(POST_PARAM_URL)
+	userAgent := req.FormValue(POST_PARAM_UAGENT)
+	if "" == targetURL || "" == userAgent || !ppstrutil.IsValidURL(targetURL) {
+		return nil
+	}
+
+	jobOptions, optionsOk := GetJobOptions(req)
+	jobControl, controlOk := GetJobControl(req)
+	if !optionsOk || !controlOk {
+		return nil
+	}
+
+	interval := int64(0)
+	if intervalStr := req.FormValue(POST_PARAM_INTERVAL); "" != intervalStr {
+		var err error
+		if interval, err = strconv.ParseInt(intervalStr, 10, 64); nil != err || 0 >= interval || INTERVAL_MAX < interval {
+			return nil
+		}
+	}
+
+	jitter := int64(0)
+	if jitterStr := req.FormValue(POST_PARAM_JITTER); "" != jitterStr {
+		var err error
+		if jitter, err = strconv.ParseInt(jitterStr, 10, 64); nil != err || 0 > jitter || JITTER_MAX < jitter {
+			return nil
+		}
+	}
+
+	ret := ppschedule.NewSchedule(targetURL, userAgent, interval, req.FormValue(POST_PARAM_CRON), jitter)
+	if nil != ret {
+		ret.JobOptions = jobOptions
+		ret.JobControl = jobControl
+	}
+
+	return ret
+}
+
+func NewAPISchedule(schedule *ppschedule.Schedule) PuppeteerWebAPISchedule {
+	return PuppeteerWebAPISchedule{
+		ID:         schedule.ID,
+		Key:        ppstrutil.URLOptions2Fingerprint(schedule.URL, schedule.JobOptions),
+		URL:        schedule.URL,
+		UserAgent:  schedule.UserAgent,
+		Interval:   schedule.Interval,
+		Cron:       schedule.Cron,
+		Jitter:     schedule.Jitter,
+		NextRun:    schedule.NextRun,
+		LastRun:    schedule.LastRun,
+		CreateTime: schedule.CreateTime,
+		Options:    schedule.JobOptions}
+}
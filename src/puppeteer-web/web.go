@@ -9,11 +9,13 @@ import (
 	"os"
 	ppconf "puppeteerlib/conf"
 	ppioutil "puppeteerlib/ioutil"
+	ppjob "puppeteerlib/job"
 	pppool "puppeteerlib/pool"
 	ppqueue "puppeteerlib/queue"
 	ppstrutil "puppeteerlib/strutil"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,19 +23,51 @@ const (
 	BODY_MAX_SIZE       = 4096
 	INFO_URI_PREFIX     = "/info/"
 	PIC_URI_PREFIX      = "/pic/"
+	HISTORY_URI_PREFIX  = "/history/"
+	GET_PARAM_VERSION   = "version"
 	HEADER_SIZE_DEFAULT = 1 << 20 //1M
 	TIMEOUT_DEFAULT     = 60      //60 seconds
 	ADDR_DEFAULT        = ""
 	PORT_DEFAULT        = 8080
 	POST_PARAM_URL      = "url"
 	POST_PARAM_UAGENT   = "userAgent"
+	POST_PARAM_WIDTH    = "width"
+	POST_PARAM_HEIGHT   = "height"
+	POST_PARAM_FULLPAGE = "fullPage"
+	POST_PARAM_FORMAT   = "format"
+	POST_PARAM_QUALITY  = "quality"
+	POST_PARAM_PAPER    = "paperSize"
+	POST_PARAM_ORIENT   = "orientation"
+	POST_PARAM_SELECTOR = "selector"
+	POST_PARAM_CLIP     = "clip"
+	POST_PARAM_DELAY    = "delayMs"
+	POST_PARAM_WAIT_SEL = "waitForSelector"
+	POST_PARAM_WAIT_NET = "waitForNetworkIdle"
+	POST_PARAM_MAX_WAIT = "maxWaitMs"
+	SELECTOR_MAX_LEN    = 512
+	WAIT_MS_MAX         = 60000
+	POST_PARAM_TIMEOUT  = "timeout"
+	JOB_TIMEOUT_MAX     = 3600
+	POST_PARAM_PRIORITY = "priority"
+	POST_PARAM_QUEUE    = "queue"
+	PRIORITY_MAX        = 9
+	POST_PARAM_RUN_AT   = "runAt"
+	POST_PARAM_DELAY_S  = "delaySeconds"
+	DELAY_SECONDS_MAX   = 30 * 86400
+	POST_PARAM_MAX_AGE  = "maxAge"
+	POST_PARAM_FORCE    = "force"
+	POST_PARAM_CALLBACK = "callbackUrl"
+	VIEWPORT_MAX        = 8192
+	QUALITY_MAX         = 100
 )
 
 const (
-	API_RET_ERR_IO     = -1
-	API_RET_OK         = 0
-	API_RET_ERR_IO_MSG = "io error"
-	API_RET_OK_MSG     = ""
+	API_RET_ERR_STATE     = -2
+	API_RET_ERR_IO        = -1
+	API_RET_OK            = 0
+	API_RET_ERR_STATE_MSG = "job not queued or running"
+	API_RET_ERR_IO_MSG    = "io error"
+	API_RET_OK_MSG        = ""
 )
 
 type PuppeteerWebAPIResponse struct {
@@ -43,9 +77,20 @@ type PuppeteerWebAPIResponse struct {
 }
 
 type PuppeteerWebAPIInfo struct {
-	Key        string
-	Status     uint8
-	LastUpdate int64
+	Key         string
+	Status      uint8
+	LastUpdate  int64
+	Reason      string
+	ExitCode    int
+	Attempts    int
+	WaitOutcome string
+	NotBefore   int64
+	Stale       bool
+}
+
+type PuppeteerWebAPIHistory struct {
+	Key      string
+	Versions []pppool.ScreenshotVersion
 }
 
 type PuppeteerWebHandler struct {
@@ -53,28 +98,60 @@ type PuppeteerWebHandler struct {
 }
 
 var gPuppeteerConf *ppconf.PuppeteerConf
+var gWriteTimeout time.Duration
+
+var gPaperSizeList = []string{"A3", "A4", "A5", "Legal", "Letter", "Tabloid"}
+var gOrientationList = []string{"portrait", "landscape"}
 
 func (this PuppeteerWebHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+	// batches are JSON bodies far beyond the size of a form.
+	if gBatchRegexp.MatchString(req.URL.Path) {
+		ServeBatch(rsp, req)
+		return
+	}
+
 	if nil != req.Body {
 		req.Body = http.MaxBytesReader(rsp, req.Body, BODY_MAX_SIZE)
 		err := req.ParseMultipartForm(BODY_MAX_SIZE)
 
-		if nil != err {
+		// url encoded forms and requests without body are parsed anyway.
+		if nil != err && http.ErrNotMultipart != err {
 			rsp.WriteHeader(http.StatusRequestEntityTooLarge)
 			return
 		}
 	}
 
+	if strings.HasPrefix(req.URL.Path, SCHEDULE_URI_PREFIX) {
+		ServeSchedule(rsp, req)
+		return
+	}
+
+	if CAPTURE_URI == req.URL.Path {
+		ServeCapture(rsp, req)
+		return
+	}
+
+	if strings.HasPrefix(req.URL.Path, ALERT_URI_PREFIX) {
+		ServeAlert(rsp, req)
+		return
+	}
+
 	pathRegexp := regexp.MustCompile("^(\\/[a-zA-Z0-9\\-\\_]+\\/)([a-f0-9]{32}\\.[\\d]+)$")
 	if "GET" == req.Method {
 		if matchList := pathRegexp.FindStringSubmatch(req.URL.Path); nil != matchList {
 			switch matchList[1] {
 			case INFO_URI_PREFIX:
-				if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo {
+				maxAge, maxAgeOk := GetMaxAge(req)
+				wait, waitOk := GetWait(req)
+				if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo && maxAgeOk && waitOk {
+					if screenshotInfo = WaitScreenshot(rsp, req, screenshotInfo, wait); nil == screenshotInfo {
+						break
+					}
+					pppool.ApplyExpire(screenshotInfo, maxAge)
 					apiResponse := PuppeteerWebAPIResponse{
 						RetCode: API_RET_OK,
 						RetMsg:  "",
-						Data:    PuppeteerWebAPIInfo{Key: screenshotInfo.Fingerprint, Status: screenshotInfo.Status, LastUpdate: screenshotInfo.LastUpdate}}
+						Data:    NewAPIInfo(screenshotInfo)}
 					jsonBytes, _ := json.Marshal(apiResponse)
 
 					rsp.Header().Set("Content-Type", "application/json")
@@ -84,17 +161,23 @@ func (this PuppeteerWebHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Req
 				}
 				break
 			case PIC_URI_PREFIX:
-				if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo {
-					if pppool.STAT_READY == screenshotInfo.Status {
-						filePath := pppool.GetScreenshotFilePath(screenshotInfo)
-						if fh, openErr := os.OpenFile(filePath, os.O_RDONLY, ppioutil.FILE_MASK); nil == openErr {
-							rsp.Header().Set("Content-Type", "image/png")
-							rsp.Header().Set("Content-Disposition", "inline; filename=screenshot.png")
-							io.Copy(rsp, fh)
-							fh.Close()
+				maxAge, maxAgeOk := GetMaxAge(req)
+				versionStr := req.FormValue(GET_PARAM_VERSION)
+				if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo && maxAgeOk {
+					pppool.ApplyExpire(screenshotInfo, maxAge)
+					if "" != versionStr {
+						// earlier versions are served as they are, they are not
+						// expected to be fresh.
+						version, _ := strconv.ParseInt(versionStr, 10, 64)
+						if versionPath := pppool.GetScreenshotVersionPath(screenshotInfo, version); "" != versionPath {
+							ServeScreenshot(rsp, screenshotInfo, versionPath, version, false)
 						} else {
 							rsp.WriteHeader(http.StatusNotFound)
 						}
+					} else if 0 < screenshotInfo.LastUpdate && ("" == req.FormValue(POST_PARAM_MAX_AGE) || !screenshotInfo.Stale) {
+						if ServeScreenshot(rsp, screenshotInfo, pppool.GetScreenshotFilePath(screenshotInfo), screenshotInfo.LastUpdate, screenshotInfo.Stale) {
+							pppool.TouchScreenshot(screenshotInfo)
+						}
 					} else {
 						rsp.WriteHeader(http.StatusNotFound)
 
@@ -103,6 +186,31 @@ func (this PuppeteerWebHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Req
 					rsp.WriteHeader(http.StatusBadRequest)
 				}
 				break
+			case HISTORY_URI_PREFIX:
+				if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo {
+					apiHistory := PuppeteerWebAPIHistory{Key: screenshotInfo.Fingerprint, Versions: pppool.ListScreenshotVersions(screenshotInfo)}
+					jsonBytes, _ := json.Marshal(PuppeteerWebAPIResponse{RetCode: API_RET_OK, Data: apiHistory})
+
+					rsp.Header().Set("Content-Type", "application/json")
+					io.WriteString(rsp, string(jsonBytes))
+				} else {
+					rsp.WriteHeader(http.StatusBadRequest)
+				}
+				break
+			case EVENTS_URI_PREFIX:
+				if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo {
+					ServeEvents(rsp, req, screenshotInfo)
+				} else {
+					rsp.WriteHeader(http.StatusBadRequest)
+				}
+				break
+			case DIFF_URI_PREFIX:
+				if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo {
+					ServeDiff(rsp, req, screenshotInfo)
+				} else {
+					rsp.WriteHeader(http.StatusBadRequest)
+				}
+				break
 			default:
 				rsp.WriteHeader(http.StatusNotFound)
 				break
@@ -114,26 +222,11 @@ func (this PuppeteerWebHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Req
 		targetURL := req.FormValue(POST_PARAM_URL)
 		userAgent := req.FormValue(POST_PARAM_UAGENT)
 
-		if req.URL.Path == INFO_URI_PREFIX && "" != targetURL && "" != userAgent && ppstrutil.IsValidURL(targetURL) {
-			fingerprint := ppstrutil.URL2Fingerprint(targetURL)
-			screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, fingerprint)
-
-			apiResponse := PuppeteerWebAPIResponse{}
-			if nil != screenshotInfo {
-				pppool.AppendScreenshotLog(screenshotInfo, fmt.Sprintf("%d\t%s\n", time.Now().Unix(), targetURL))
-				jobData := map[string]string{ppqueue.URL: targetURL,
-					ppqueue.TARGET_FILE: pppool.GetScreenshotFilePath(screenshotInfo),
-					ppqueue.LOG_FILE:    pppool.GetScreenshotLogPath(screenshotInfo),
-					ppqueue.USER_AGENT:  userAgent}
-				if ppqueue.WriteJob(gPuppeteerConf.QueueDir, jobData) {
-					apiResponse.RetCode = API_RET_OK
-					apiResponse.Data = PuppeteerWebAPIInfo{Key: screenshotInfo.Fingerprint, Status: pppool.STAT_RUNNING, LastUpdate: 0}
-				} else {
-					apiResponse.RetCode = API_RET_ERR_IO
-					apiResponse.RetMsg = API_RET_ERR_IO_MSG
-					apiResponse.Data = PuppeteerWebAPIInfo{Key: screenshotInfo.Fingerprint, Status: pppool.STAT_RUNNING, LastUpdate: 0}
-				}
-			}
+		jobOptions, optionsOk := GetJobOptions(req)
+		jobControl, controlOk := GetJobControl(req)
+
+		if req.URL.Path == INFO_URI_PREFIX && "" != targetURL && "" != userAgent && ppstrutil.IsValidURL(targetURL) && optionsOk && controlOk {
+			apiResponse := SubmitJob(targetURL, userAgent, jobOptions, jobControl)
 			jsonBytes, _ := json.Marshal(apiResponse)
 
 			rsp.Header().Set("Content-Type", "application/json")
@@ -141,7 +234,411 @@ func (this PuppeteerWebHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Req
 		} else {
 			rsp.WriteHeader(http.StatusBadRequest)
 		}
+	} else if "DELETE" == req.Method {
+		if matchList := pathRegexp.FindStringSubmatch(req.URL.Path); nil != matchList && INFO_URI_PREFIX == matchList[1] {
+			if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, matchList[2]); nil != screenshotInfo {
+				jsonBytes, _ := json.Marshal(CancelJob(screenshotInfo))
+
+				rsp.Header().Set("Content-Type", "application/json")
+				io.WriteString(rsp, string(jsonBytes))
+			} else {
+				rsp.WriteHeader(http.StatusBadRequest)
+			}
+		} else {
+			rsp.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
+
+// ServeScreenshot sends the screenshot file at filePath rendered at
+// lastUpdate, and returns false if there is no such file.
+func ServeScreenshot(rsp http.ResponseWriter, screenshotInfo *pppool.ScreenshotInfo, filePath string, lastUpdate int64, stale bool) bool {
+	fh, openErr := os.OpenFile(filePath, os.O_RDONLY, ppioutil.FILE_MASK)
+	if nil != openErr {
+		rsp.WriteHeader(http.StatusNotFound)
+		return false
+	}
+	defer fh.Close()
+
+	disposition := "inline"
+	if pppool.FORMAT_PDF == screenshotInfo.Format {
+		disposition = "attachment"
+	}
+	rsp.Header().Set("Content-Type", pppool.GetFormatContentType(screenshotInfo.Format))
+	rsp.Header().Set("Content-Disposition", disposition+"; filename=screenshot"+pppool.GetFormatPrefix(screenshotInfo.Format))
+	rsp.Header().Set("Last-Modified", time.Unix(lastUpdate, 0).UTC().Format(http.TimeFormat))
+	if stale {
+		rsp.Header().Set("Warning", "110 - \"Response is Stale\"")
+	}
+	io.Copy(rsp, fh)
+
+	return true
+}
+
+// CancelJob withdraws the queued or running job of the screenshot. Waiting
+// jobs are removed at once, a running job is killed by its slave, which
+// then records the cancellation.
+func CancelJob(screenshotInfo *pppool.ScreenshotInfo) PuppeteerWebAPIResponse {
+	apiResponse := PuppeteerWebAPIResponse{}
+	queueDir := gPuppeteerConf.QueueDir
+	key := screenshotInfo.Fingerprint
+
+	if pppool.STAT_QUEUED != screenshotInfo.Status && pppool.STAT_RUNNING != screenshotInfo.Status {
+		apiResponse.RetCode = API_RET_ERR_STATE
+		apiResponse.RetMsg = API_RET_ERR_STATE_MSG
+		apiResponse.Data = NewAPIInfo(screenshotInfo)
+		return apiResponse
+	}
+
+	if !ppqueue.AddCancel(queueDir, key) {
+		apiResponse.RetCode = API_RET_ERR_IO
+		apiResponse.RetMsg = API_RET_ERR_IO_MSG
+		apiResponse.Data = NewAPIInfo(screenshotInfo)
+		return apiResponse
+	}
+
+	jobList := ppqueue.RemoveWaitJobs(queueDir, key)
+	if !ppqueue.HasRunJob(queueDir, key) {
+		pppool.UpdateStateFile(pppool.GetScreenshotStatePath(screenshotInfo), map[string]string{pppool.STATE: pppool.STATE_CANCELLED})
+		ppqueue.RemoveInflight(queueDir, key)
+		ppqueue.RemoveCancel(queueDir, key)
+		screenshotInfo.Status = pppool.STAT_CANCELLED
+
+		// the callbacks of coalesced submissions are told even if the job
+		// itself is gone already.
+		if 0 == len(jobList) {
+			jobList = append(jobList, map[string]string{ppqueue.KEY: key})
+		}
+		for _, jobInfo := range jobList {
+			ppjob.Complete(gPuppeteerConf, jobInfo)
+		}
+	}
+
+	apiResponse.RetCode = API_RET_OK
+	apiResponse.Data = NewAPIInfo(screenshotInfo)
+
+	return apiResponse
+}
+
+// SubmitJob queues a job for targetURL and returns the API response
+// telling its key and status. No job is queued if the screenshot is within
+// the maximum age of the job, unless the job is delayed.
+func SubmitJob(targetURL string, userAgent string, jobOptions map[string]string, jobControl map[string]string) PuppeteerWebAPIResponse {
+	apiResponse := PuppeteerWebAPIResponse{}
+	maxAge := ppqueue.GetJobMaxAge(jobControl, gPuppeteerConf.Expire)
+
+	if _, delayed := jobControl[ppqueue.NOT_BEFORE]; !delayed {
+		fingerprint := ppstrutil.URLOptions2Fingerprint(targetURL, jobOptions)
+		if screenshotInfo := pppool.GetScreenshotInfoByFingerprint(gPuppeteerConf.PoolDir, fingerprint); nil != screenshotInfo && pppool.IsFresh(screenshotInfo, maxAge) {
+			pppool.ApplyExpire(screenshotInfo, maxAge)
+			apiResponse.RetCode = API_RET_OK
+			apiResponse.Data = NewAPIInfo(screenshotInfo)
+			return apiResponse
+		}
+	}
+
+	screenshotInfo, submitResult := ppjob.Submit(gPuppeteerConf, targetURL, userAgent, jobOptions, jobControl)
+
+	if nil != screenshotInfo {
+		apiResponse.RetCode = API_RET_OK
+		if ppjob.SUBMIT_ERR == submitResult {
+			apiResponse.RetCode = API_RET_ERR_IO
+			apiResponse.RetMsg = API_RET_ERR_IO_MSG
+		}
+		pppool.ApplyExpire(screenshotInfo, maxAge)
+		apiResponse.Data = NewAPIInfo(screenshotInfo)
+	}
+
+	return apiResponse
+}
+
+func NewAPIInfo(screenshotInfo *pppool.ScreenshotInfo) PuppeteerWebAPIInfo {
+	return PuppeteerWebAPIInfo{
+		Key:         screenshotInfo.Fingerprint,
+		Status:      screenshotInfo.Status,
+		LastUpdate:  screenshotInfo.LastUpdate,
+		Reason:      screenshotInfo.Reason,
+		ExitCode:    screenshotInfo.ExitCode,
+		Attempts:    screenshotInfo.Attempts,
+		WaitOutcome: screenshotInfo.WaitOutcome,
+		NotBefore:   screenshotInfo.NotBefore,
+		Stale:       screenshotInfo.Stale}
+}
+
+// GetJobControl validates the optional POST parameters which control how
+// the job is run rather than what is rendered, thus do not take part in
+// the fingerprint.
+func GetJobControl(req *http.Request) (map[string]string, bool) {
+	ret := make(map[string]string)
+
+	if timeoutStr := req.FormValue(POST_PARAM_TIMEOUT); "" != timeoutStr {
+		timeout, err := strconv.ParseInt(timeoutStr, 10, 64)
+		if nil != err || 0 >= timeout || JOB_TIMEOUT_MAX < timeout {
+			return nil, false
+		}
+		ret[ppqueue.TIMEOUT] = strconv.FormatInt(timeout, 10)
+	}
+
+	queueName := req.FormValue(POST_PARAM_QUEUE)
+	if "" == queueName {
+		queueName = ppqueue.DEFAULT_QUEUE
+	}
+	queueConf := gPuppeteerConf.GetQueueConf(queueName)
+	if nil == queueConf {
+		return nil, false
+	}
+	ret[ppqueue.QUEUE] = queueConf.Name
+	ret[ppqueue.PRIORITY] = strconv.FormatInt(queueConf.Priority, 10)
+
+	if priorityStr := req.FormValue(POST_PARAM_PRIORITY); "" != priorityStr {
+		priority, err := strconv.ParseInt(priorityStr, 10, 64)
+		if nil != err || 0 > priority || PRIORITY_MAX < priority {
+			return nil, false
+		}
+		ret[ppqueue.PRIORITY] = strconv.FormatInt(priority, 10)
+	}
+
+	maxAge, maxAgeOk := GetMaxAge(req)
+	if !maxAgeOk {
+		return nil, false
+	}
+	if forceStr := req.FormValue(POST_PARAM_FORCE); "" != forceStr {
+		force, err := strconv.ParseBool(forceStr)
+		if nil != err {
+			return nil, false
+		}
+		if force {
+			maxAge = 0
+		}
+	}
+	if gPuppeteerConf.Expire != maxAge {
+		ret[ppqueue.MAX_AGE] = strconv.FormatInt(maxAge, 10)
+	}
+
+	notBefore, notBeforeOk := GetJobNotBefore(req)
+	if !notBeforeOk {
+		return nil, false
+	}
+	if 0 < notBefore {
+		ret[ppqueue.NOT_BEFORE] = strconv.FormatInt(notBefore, 10)
+	}
+
+	if callbackURL := req.FormValue(POST_PARAM_CALLBACK); "" != callbackURL {
+		if !ppstrutil.IsValidURL(callbackURL) {
+			return nil, false
+		}
+		ret[ppqueue.CALLBACK_URL] = callbackURL
+	}
+
+	return ret, true
+}
+
+// GetMaxAge returns the maxAge parameter, the age in seconds up to which
+// a screenshot is considered fresh, or Expire of the configuration if it
+// is not given.
+func GetMaxAge(req *http.Request) (int64, bool) {
+	maxAgeStr := req.FormValue(POST_PARAM_MAX_AGE)
+	if "" == maxAgeStr {
+		return gPuppeteerConf.Expire, true
+	}
+
+	maxAge, err := strconv.ParseInt(maxAgeStr, 10, 64)
+	if nil != err || 0 > maxAge {
+		return 0, false
+	}
+
+	return maxAge, true
+}
+
+// GetJobNotBefore returns the time before which the job must not run,
+// given either as runAt, a unix timestamp or an RFC 3339 time, or as
+// delaySeconds from now. It returns 0 if the job may run at once.
+func GetJobNotBefore(req *http.Request) (int64, bool) {
+	runAtStr := req.FormValue(POST_PARAM_RUN_AT)
+	delayStr := req.FormValue(POST_PARAM_DELAY_S)
+	now := time.Now().Unix()
+	ret := int64(0)
+
+	if "" != runAtStr && "" != delayStr {
+		return 0, false
+	}
+
+	if "" != runAtStr {
+		runAt, err := strconv.ParseInt(runAtStr, 10, 64)
+		if nil != err {
+			runAtTime, timeErr := time.Parse(time.RFC3339, runAtStr)
+			if nil != timeErr {
+				return 0, false
+			}
+			runAt = runAtTime.Unix()
+		}
+		if 0 >= runAt || now+DELAY_SECONDS_MAX < runAt {
+			return 0, false
+		}
+		ret = runAt
+	}
+
+	if "" != delayStr {
+		delay, err := strconv.ParseInt(delayStr, 10, 64)
+		if nil != err || 0 > delay || DELAY_SECONDS_MAX < delay {
+			return 0, false
+		}
+		ret = now + delay
+	}
+
+	return ret, true
+}
+
+// GetJobOptions validates the optional render parameters of a POST request
+// and returns them keyed by job property name. Options equal to the render
+// defaults are left out, so that they do not change the fingerprint.
+func GetJobOptions(req *http.Request) (map[string]string, bool) {
+	ret := make(map[string]string)
+
+	if widthStr := req.FormValue(POST_PARAM_WIDTH); "" != widthStr {
+		width, err := strconv.ParseUint(widthStr, 10, 16)
+		if nil != err || 0 == width || VIEWPORT_MAX < width {
+			return nil, false
+		}
+		ret[ppqueue.WIDTH] = strconv.FormatUint(width, 10)
 	}
+
+	if heightStr := req.FormValue(POST_PARAM_HEIGHT); "" != heightStr {
+		height, err := strconv.ParseUint(heightStr, 10, 16)
+		if nil != err || 0 == height || VIEWPORT_MAX < height {
+			return nil, false
+		}
+		ret[ppqueue.HEIGHT] = strconv.FormatUint(height, 10)
+	}
+
+	if fullPageStr := req.FormValue(POST_PARAM_FULLPAGE); "" != fullPageStr {
+		fullPage, err := strconv.ParseBool(fullPageStr)
+		if nil != err {
+			return nil, false
+		}
+		if !fullPage {
+			ret[ppqueue.FULL_PAGE] = "0"
+		}
+	}
+
+	format := req.FormValue(POST_PARAM_FORMAT)
+	if "" != format && pppool.FORMAT_PNG != format {
+		if !pppool.IsValidFormat(format) {
+			return nil, false
+		}
+		ret[ppqueue.FORMAT] = format
+	}
+
+	if qualityStr := req.FormValue(POST_PARAM_QUALITY); "" != qualityStr {
+		quality, err := strconv.ParseUint(qualityStr, 10, 8)
+		if nil != err || 0 == quality || QUALITY_MAX < quality || pppool.FORMAT_JPEG != format {
+			return nil, false
+		}
+		ret[ppqueue.QUALITY] = strconv.FormatUint(quality, 10)
+	}
+
+	if paperSize := req.FormValue(POST_PARAM_PAPER); "" != paperSize {
+		if !IsInList(paperSize, gPaperSizeList) || pppool.FORMAT_PDF != format {
+			return nil, false
+		}
+		ret[ppqueue.PAPER_SIZE] = paperSize
+	}
+
+	if orientation := req.FormValue(POST_PARAM_ORIENT); "" != orientation {
+		if !IsInList(orientation, gOrientationList) || pppool.FORMAT_PDF != format {
+			return nil, false
+		}
+		ret[ppqueue.ORIENTATION] = orientation
+	}
+
+	if selector := req.FormValue(POST_PARAM_SELECTOR); "" != selector {
+		if SELECTOR_MAX_LEN < len(selector) || strings.ContainsAny(selector, "\r\n") {
+			return nil, false
+		}
+		ret[ppqueue.SELECTOR] = selector
+	}
+
+	if clipStr := req.FormValue(POST_PARAM_CLIP); "" != clipStr {
+		clip, clipOk := ParseClip(clipStr)
+		if !clipOk {
+			return nil, false
+		}
+		ret[ppqueue.CLIP] = clip
+	}
+
+	if "" != ret[ppqueue.SELECTOR] && "" != ret[ppqueue.CLIP] {
+		return nil, false
+	}
+
+	if delayStr := req.FormValue(POST_PARAM_DELAY); "" != delayStr {
+		delay, err := strconv.ParseUint(delayStr, 10, 32)
+		if nil != err || WAIT_MS_MAX < delay {
+			return nil, false
+		}
+		if 0 < delay {
+			ret[ppqueue.DELAY_MS] = strconv.FormatUint(delay, 10)
+		}
+	}
+
+	if waitSelector := req.FormValue(POST_PARAM_WAIT_SEL); "" != waitSelector {
+		if SELECTOR_MAX_LEN < len(waitSelector) || strings.ContainsAny(waitSelector, "\r\n") {
+			return nil, false
+		}
+		ret[ppqueue.WAIT_SELECTOR] = waitSelector
+	}
+
+	if waitNetStr := req.FormValue(POST_PARAM_WAIT_NET); "" != waitNetStr {
+		waitNet, err := strconv.ParseBool(waitNetStr)
+		if nil != err {
+			return nil, false
+		}
+		if waitNet {
+			ret[ppqueue.WAIT_NET_IDLE] = "1"
+		}
+	}
+
+	if maxWaitStr := req.FormValue(POST_PARAM_MAX_WAIT); "" != maxWaitStr {
+		maxWait, err := strconv.ParseUint(maxWaitStr, 10, 32)
+		if nil != err || 0 == maxWait || WAIT_MS_MAX < maxWait {
+			return nil, false
+		}
+		if "" == ret[ppqueue.WAIT_SELECTOR] && "" == ret[ppqueue.WAIT_NET_IDLE] {
+			return nil, false
+		}
+		ret[ppqueue.MAX_WAIT_MS] = strconv.FormatUint(maxWait, 10)
+	}
+
+	return ret, true
+}
+
+// ParseClip validates a clip rectangle given as "x,y,w,h" and returns it
+// in canonical form.
+func ParseClip(clipStr string) (string, bool) {
+	partList := strings.Split(clipStr, ",")
+	if 4 != len(partList) {
+		return "", false
+	}
+
+	valList := make([]string, 0, len(partList))
+	for idx, part := range partList {
+		val, err := strconv.ParseUint(strings.TrimSpace(part), 10, 16)
+		if nil != err || (2 <= idx && (0 == val || VIEWPORT_MAX < val)) {
+			return "", false
+		}
+		valList = append(valList, strconv.FormatUint(val, 10))
+	}
+
+	return strings.Join(valList, ","), true
+}
+
+func IsInList(val string, valList []string) bool {
+	for _, listVal := range valList {
+		if val == listVal {
+			return true
+		}
+	}
+
+	return false
 }
 
 func main() {
@@ -156,6 +653,8 @@ func main() {
 	}
 
 	gPuppeteerConf = conf
+	gWriteTimeout = time.Duration(timeout) * time.Second
+	gDirWatcher = ppioutil.NewDirWatcher()
 	if logHandle, logErr := os.OpenFile(gPuppeteerConf.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, ppioutil.FILE_MASK); nil == logErr {
 		log.SetOutput(logHandle)
 		log.SetFlags(log.LstdFlags)
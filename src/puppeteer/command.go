@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	ppbatch "puppeteerlib/batch"
+	ppconf "puppeteerlib/conf"
+	pppool "puppeteerlib/pool"
+	ppqueue "puppeteerlib/queue"
+	"time"
+)
+
+const (
+	CMD_DEAD   = "dead"
+	CMD_REPLAY = "replay"
+	CMD_GC     = "gc"
+)
+
+// RunCommand runs a one-shot maintenance command instead of the daemon,
+// and returns false for an unknown command.
+func RunCommand(puppeteerConf *ppconf.PuppeteerConf, cmdArgs []string) bool {
+	switch cmdArgs[0] {
+	case CMD_DEAD:
+		ListDeadJobs(puppeteerConf)
+		return true
+	case CMD_REPLAY:
+		ReplayDeadJobs(puppeteerConf, cmdArgs[1:])
+		return true
+	case CMD_GC:
+		batchCnt := ppbatch.RemoveBatches(puppeteerConf.QueueDir, time.Now().Unix()-puppeteerConf.BatchRetention)
+		fmt.Printf("removed %d batches\n", batchCnt)
+		if 0 < puppeteerConf.Retention {
+			gcStat := RunGC(puppeteerConf)
+			fmt.Printf("removed %d files of %d bytes and %d dirs\n", gcStat.FileCnt, gcStat.ByteCnt, gcStat.DirCnt)
+		}
+		if 0 < puppeteerConf.PoolMaxBytes {
+			gcStat := RunEvict(puppeteerConf)
+			fmt.Printf("evicted %d files of %d bytes and %d dirs\n", gcStat.FileCnt, gcStat.ByteCnt, gcStat.DirCnt)
+		}
+		return true
+	}
+
+	return false
+}
+
+func ListDeadJobs(puppeteerConf *ppconf.PuppeteerConf) {
+	deadDir := ppqueue.GetJobDeadDir(puppeteerConf.QueueDir)
+
+	for _, jobName := range ppqueue.ListJobDir(deadDir) {
+		if jobInfo := ppqueue.ReadJob(deadDir + string(os.PathSeparator) + jobName); nil != jobInfo {
+			fmt.Printf("%s\t%s\t%s\t%s\n", jobName, jobInfo[ppqueue.FAIL_REASON], jobInfo[ppqueue.ATTEMPTS], jobInfo[ppqueue.URL])
+		}
+	}
+}
+
+// ReplayDeadJobs moves the named dead jobs, or all of them if none is
+// named, back to the wait queue with a fresh retry budget.
+func ReplayDeadJobs(puppeteerConf *ppconf.PuppeteerConf, jobNameList []string) {
+	deadDir := ppqueue.GetJobDeadDir(puppeteerConf.QueueDir)
+
+	if 0 == len(jobNameList) {
+		jobNameList = ppqueue.ListJobDir(deadDir)
+	}
+
+	for _, jobName := range jobNameList {
+		deadFile := deadDir + string(os.PathSeparator) + jobName
+		jobInfo := ppqueue.ReadJob(deadFile)
+		if nil == jobInfo {
+			fmt.Printf("%s\tnot found\n", jobName)
+			continue
+		}
+
+		// a submission may have queued the key again since the job died,
+		// jobs queued before keys were recorded are replayed anyway.
+		if "" != jobInfo[ppqueue.KEY] && !ppqueue.AddInflight(puppeteerConf.QueueDir, jobInfo[ppqueue.KEY]) {
+			fmt.Printf("%s\talready in flight\n", jobName)
+			continue
+		}
+
+		delete(jobInfo, ppqueue.ATTEMPTS)
+		delete(jobInfo, ppqueue.NOT_BEFORE)
+		delete(jobInfo, ppqueue.FAIL_REASON)
+		if !ppqueue.WriteJob(puppeteerConf.QueueDir, jobInfo) {
+			ppqueue.RemoveInflight(puppeteerConf.QueueDir, jobInfo[ppqueue.KEY])
+			fmt.Printf("%s\tio error\n", jobName)
+			continue
+		}
+
+		os.Remove(deadFile)
+		pppool.UpdateStateFile(jobInfo[ppqueue.STATE_FILE], map[string]string{
+			pppool.STATE:    pppool.STATE_QUEUED,
+			pppool.ATTEMPTS: "0"})
+		fmt.Printf("%s\treplayed\n", jobName)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	ppbatch "puppeteerlib/batch"
+	ppconf "puppeteerlib/conf"
+	pppool "puppeteerlib/pool"
+	ppqueue "puppeteerlib/queue"
+	"time"
+)
+
+const (
+	EVICT_INTERVAL = time.Minute
+)
+
+// GarbageCollector removes the pool files older than Retention and the
+// batches older than BatchRetention every GCInterval seconds, and evicts
+// the least recently used screenshots whenever the pool grows beyond
+// PoolMaxBytes. Pool files are kept unless Retention or PoolMaxBytes is
+// configured.
+func GarbageCollector(scoreboard *Scoreboard) {
+	scoreboard.Lock.RLock()
+	jobConf := *scoreboard.Conf
+	scoreboard.Lock.RUnlock()
+
+	log.Printf("gc starts")
+	gcInterval := time.Duration(jobConf.GCInterval) * time.Second
+	lastGC := time.Time{}
+	lastEvict := time.Time{}
+	for {
+		if scoreboard.IsTerminated() {
+			break
+		}
+
+		if gcInterval <= time.Since(lastGC) {
+			if 0 < jobConf.Retention {
+				gcStat := RunGC(&jobConf)
+				log.Printf("gc removes %d files of %d bytes and %d dirs\n", gcStat.FileCnt, gcStat.ByteCnt, gcStat.DirCnt)
+			}
+			if batchCnt := ppbatch.RemoveBatches(jobConf.QueueDir, time.Now().Unix()-jobConf.BatchRetention); 0 < batchCnt {
+				log.Printf("gc removes %d batches\n", batchCnt)
+			}
+			lastGC = time.Now()
+		}
+
+		if 0 < jobConf.PoolMaxBytes && EVICT_INTERVAL <= time.Since(lastEvict) {
+			if gcStat := RunEvict(&jobConf); 0 < gcStat.FileCnt {
+				log.Printf("gc evicts %d files of %d bytes and %d dirs\n", gcStat.FileCnt, gcStat.ByteCnt, gcStat.DirCnt)
+			}
+			lastEvict = time.Now()
+		}
+		time.Sleep(time.Second)
+	}
+
+	log.Printf("gc stops\n")
+}
+
+// isJobInflight tells whether the files of key must be kept for a queued
+// or running job.
+func isJobInflight(jobConf *ppconf.PuppeteerConf) func(string) bool {
+	return func(key string) bool {
+		return ppqueue.IsInflight(jobConf.QueueDir, key)
+	}
+}
+
+// RunGC removes the pool files older than the retention, except those of
+// the jobs in flight.
+func RunGC(jobConf *ppconf.PuppeteerConf) pppool.GCStat {
+	return pppool.CollectGarbage(jobConf.PoolDir, jobConf.Retention, isJobInflight(jobConf))
+}
+
+// RunEvict removes the least recently used screenshots, except those of
+// the jobs in flight, if the pool takes more than PoolMaxBytes.
+func RunEvict(jobConf *ppconf.PuppeteerConf) pppool.GCStat {
+	return pppool.EvictPool(jobConf.PoolDir, jobConf.PoolMaxBytes, isJobInflight(jobConf))
+}
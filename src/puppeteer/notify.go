@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	ppconf "puppeteerlib/conf"
+	ppdiff "puppeteerlib/diff"
+	ppioutil "puppeteerlib/ioutil"
+	ppnotify "puppeteerlib/notify"
+	pppool "puppeteerlib/pool"
+	ppqueue "puppeteerlib/queue"
+	"time"
+)
+
+const (
+	NOTIFY_RESCAN_INTERVAL = time.Minute
+)
+
+// Notifier posts the notifications waiting in the notify directory of
+// QueueDir, retrying failed posts with backoff up to NotifyMaxAttempts.
+// Notifications are kept on disk, so they survive a restart.
+func Notifier(scoreboard *Scoreboard) {
+	scoreboard.Lock.RLock()
+	jobConf := *scoreboard.Conf
+	scoreboard.Lock.RUnlock()
+
+	log.Printf("notifier starts")
+	watchChannel := ppioutil.WatchDir(ppnotify.GetNotifyDir(jobConf.QueueDir))
+
+	for {
+		if scoreboard.IsTerminated() {
+			break
+		}
+
+		nextTry := RunNotifications(&jobConf)
+
+		// new notifications are announced by the watch.
+		waitDuration := NOTIFY_RESCAN_INTERVAL
+		if nil == watchChannel {
+			waitDuration = RESCAN_INTERVAL
+		}
+		if untilNextTry := time.Until(time.Unix(nextTry, 0)); 0 < nextTry && untilNextTry < waitDuration {
+			waitDuration = untilNextTry
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case _, watchValid := <-watchChannel:
+			if !watchValid {
+				watchChannel = nil
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	log.Printf("notifier stops\n")
+}
+
+// RunNotifications posts the notifications which are due, and returns when
+// the next one is due, 0 if none is waiting.
+func RunNotifications(jobConf *ppconf.PuppeteerConf) int64 {
+	ret := int64(0)
+	timeout := time.Duration(jobConf.NotifyTimeout) * time.Second
+
+	for _, notification := range ppnotify.ListNotifications(jobConf.QueueDir) {
+		if now := time.Now().Unix(); notification.NextTry > now {
+			if 0 == ret || notification.NextTry < ret {
+				ret = notification.NextTry
+			}
+			continue
+		}
+
+		err := ppnotify.Deliver(notification, timeout)
+		if nil == err {
+			log.Printf("notification %s of %s delivered to %s\n", notification.ID, notification.Event, notification.TargetURL)
+			ppnotify.RemoveNotification(jobConf.QueueDir, notification.ID)
+			continue
+		}
+
+		notification.Attempts++
+		if notification.Attempts >= jobConf.NotifyMaxAttempts {
+			log.Printf("notification %s to %s dropped after %d attempts - %s\n", notification.ID, notification.TargetURL, notification.Attempts, err.Error())
+			ppnotify.RemoveNotification(jobConf.QueueDir, notification.ID)
+			continue
+		}
+
+		notification.NextTry = time.Now().Unix() + GetRetryBackoff(notification.Attempts, jobConf)
+		log.Printf("notification %s to %s failed, retry after %d - %s\n", notification.ID, notification.TargetURL, notification.NextTry, err.Error())
+		ppnotify.WriteNotification(jobConf.QueueDir, notification)
+		if 0 == ret || notification.NextTry < ret {
+			ret = notification.NextTry
+		}
+	}
+
+	return ret
+}
+
+// GetKeepVersions returns how many screenshots of the job key are kept.
+// The previous screenshot is kept at least for keys with an alert, to be
+// compared with the next one.
+func GetKeepVersions(jobInfo map[string]string, jobConf *ppconf.PuppeteerConf) int64 {
+	if 2 > jobConf.KeepVersions && nil != ppnotify.ReadAlert(jobConf.QueueDir, jobInfo[ppqueue.KEY]) {
+		return 2
+	}
+
+	return jobConf.KeepVersions
+}
+
+// CheckChange compares the new screenshot of the job key with the
+// previous one, and queues a change notification if the key has an alert
+// and the change exceeds its threshold.
+func CheckChange(jobInfo map[string]string, jobConf *ppconf.PuppeteerConf) {
+	alert := ppnotify.ReadAlert(jobConf.QueueDir, jobInfo[ppqueue.KEY])
+	if nil == alert {
+		return
+	}
+
+	screenshotInfo := pppool.GetScreenshotInfoByFingerprint(jobConf.PoolDir, alert.Key)
+	versionList := pppool.ListScreenshotVersions(screenshotInfo)
+	if pppool.FORMAT_PDF == screenshotInfo.Format || 2 > len(versionList) {
+		return
+	}
+
+	from, to := versionList[1].Version, versionList[0].Version
+	fromImage, fromErr := ppdiff.LoadImage(pppool.GetScreenshotVersionPath(screenshotInfo, from))
+	toImage, toErr := ppdiff.LoadImage(pppool.GetScreenshotVersionPath(screenshotInfo, to))
+	if ppdiff.ErrTooLarge == fromErr || ppdiff.ErrTooLarge == toErr {
+		log.Printf("screenshots of %s too large to compare\n", alert.Key)
+		return
+	}
+	if nil != fromErr || nil != toErr {
+		log.Printf("load screenshots of %s to compare failed\n", alert.Key)
+		return
+	}
+
+	diffResult := ppdiff.Compare(fromImage, toImage, ppdiff.THRESHOLD_DEFAULT, false)
+	if nil == diffResult {
+		log.Printf("screenshots of %s too large to compare\n", alert.Key)
+		return
+	}
+	if diffResult.ChangedRatio <= alert.Threshold {
+		return
+	}
+
+	payload, _ := json.Marshal(ppnotify.ChangePayload{
+		Event:        ppnotify.EVENT_CHANGE,
+		Key:          alert.Key,
+		URL:          jobInfo[ppqueue.URL],
+		ScheduleID:   alert.ScheduleID,
+		From:         from,
+		To:           to,
+		ChangedRatio: diffResult.ChangedRatio,
+		Threshold:    alert.Threshold,
+		RegionCnt:    diffResult.RegionCnt,
+		Regions:      diffResult.Regions,
+		Time:         time.Now().Unix()})
+
+	secret := alert.Secret
+	if "" == secret {
+		secret = jobConf.WebhookSecret
+	}
+
+	log.Printf("screenshot of %s changed by %f, notify %s\n", alert.Key, diffResult.ChangedRatio, alert.Webhook)
+	if !ppnotify.Enqueue(jobConf.QueueDir, alert.Webhook, secret, ppnotify.EVENT_CHANGE, payload) {
+		log.Printf("queue notification of %s failed\n", alert.Key)
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	ppconf "puppeteerlib/conf"
 	ppioutil "puppeteerlib/ioutil"
+	ppjob "puppeteerlib/job"
+	pppool "puppeteerlib/pool"
 	ppqueue "puppeteerlib/queue"
+	ppstrutil "puppeteerlib/strutil"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,6 +31,15 @@ const (
 	MAX_PROC_DEFAULT  = "5"
 	POOL_DIR_DEFAULT  = "/puppeteer/pool"
 	QUEUE_DIR_DEFAULT = "/puppeteer/queue"
+	JS_EXIT_NO_MATCH  = 2
+	POLL_INTERVAL     = time.Second
+	RESCAN_INTERVAL   = 5 * time.Second
+)
+
+const (
+	CMD_DONE = iota
+	CMD_TIMEOUT
+	CMD_CANCELLED
 )
 
 type PuppeteerConf struct {
@@ -35,10 +51,22 @@ type PuppeteerConf struct {
 }
 
 type Scoreboard struct {
-	Conf      *ppconf.PuppeteerConf
-	Lock      *sync.RWMutex
-	procCnt   uint8
-	terminate bool
+	Conf        *ppconf.PuppeteerConf
+	Lock        *sync.RWMutex
+	SlotChannel chan bool
+	procCnt     uint8
+	busyCnt     int64
+	busyMap     map[string]int64
+	hostBusyMap map[string]int64
+	hostNextMap map[string]time.Time
+	slotMap     map[string]*JobSlot
+	terminate   bool
+}
+
+// JobSlot is a slave taken for a dispatched job, until it is released.
+type JobSlot struct {
+	Queue  string
+	Domain string
 }
 
 func (this *Scoreboard) IsTerminated() bool {
@@ -84,11 +112,114 @@ func (this *Scoreboard) DecrProcCnt() {
 	this.Lock.Unlock()
 }
 
+// AcquireSlot takes a slave for the job, unless all idle slaves are
+// reserved for other queues, or the host of the job runs as many jobs as
+// allowed or started one less than its minimum interval ago. The master
+// counts as one process, thus there are procCnt - 1 slaves.
+func (this *Scoreboard) AcquireSlot(jobFileInfo *ppqueue.JobFileInfo, hostConf ppconf.HostConf) bool {
+	ret := false
+	queueName := jobFileInfo.Queue
+	now := time.Now()
+	this.Lock.Lock()
+	defer this.Lock.Unlock()
+
+	// the job is dispatched already, but not yet picked up.
+	if _, ok := this.slotMap[jobFileInfo.Name]; ok {
+		return false
+	}
+
+	if "" != hostConf.Domain {
+		if 0 < hostConf.MaxProc && hostConf.MaxProc <= this.hostBusyMap[hostConf.Domain] {
+			return false
+		}
+
+		if now.Before(this.hostNextMap[hostConf.Domain]) {
+			return false
+		}
+	}
+
+	idleCnt := int64(this.procCnt) - 1 - this.busyCnt
+	for _, queueConf := range this.Conf.QueueList {
+		if queueName != queueConf.Name && queueConf.Reserve > this.busyMap[queueConf.Name] {
+			idleCnt -= queueConf.Reserve - this.busyMap[queueConf.Name]
+		}
+	}
+
+	if 0 < idleCnt {
+		this.busyCnt++
+		this.busyMap[queueName]++
+		this.slotMap[jobFileInfo.Name] = &JobSlot{Queue: queueName, Domain: hostConf.Domain}
+		if "" != hostConf.Domain {
+			this.hostBusyMap[hostConf.Domain]++
+			if 0 < hostConf.MinInterval {
+				this.hostNextMap[hostConf.Domain] = now.Add(time.Duration(hostConf.MinInterval) * time.Second)
+			}
+		}
+		ret = true
+	}
+
+	return ret
+}
+
+// ReleaseSlot frees the slave taken for the job of jobFileName.
+func (this *Scoreboard) ReleaseSlot(jobFileName string) {
+	this.Lock.Lock()
+	if jobSlot, ok := this.slotMap[jobFileName]; ok {
+		delete(this.slotMap, jobFileName)
+		this.busyMap[jobSlot.Queue]--
+		this.busyCnt--
+
+		if "" != jobSlot.Domain {
+			this.hostBusyMap[jobSlot.Domain]--
+			if 0 >= this.hostBusyMap[jobSlot.Domain] {
+				delete(this.hostBusyMap, jobSlot.Domain)
+			}
+		}
+	}
+	this.Lock.Unlock()
+
+	select {
+	case this.SlotChannel <- true:
+	default:
+	}
+}
+
+// GetHostNextStart returns the unix time, rounded up, at which a job of
+// domain may start next, or 0 if it may start now.
+func (this *Scoreboard) GetHostNextStart(domain string) int64 {
+	ret := int64(0)
+	this.Lock.RLock()
+	if hostNext, ok := this.hostNextMap[domain]; ok && time.Now().Before(hostNext) {
+		ret = hostNext.Add(time.Second - 1).Unix()
+	}
+	this.Lock.RUnlock()
+
+	return ret
+}
+
+// PruneHosts forgets the next start times which have passed.
+func (this *Scoreboard) PruneHosts() {
+	now := time.Now()
+	this.Lock.Lock()
+	for domain, hostNext := range this.hostNextMap {
+		if !now.Before(hostNext) {
+			delete(this.hostNextMap, domain)
+		}
+	}
+	this.Lock.Unlock()
+}
+
 func NewScoreboard(conf *ppconf.PuppeteerConf) *Scoreboard {
 	ret := new(Scoreboard)
 	ret.Conf = conf
 	ret.Lock = new(sync.RWMutex)
+	ret.SlotChannel = make(chan bool, 1)
 	ret.procCnt = 0
+	ret.busyCnt = 0
+	ret.busyMap = make(map[string]int64)
+	ret.hostBusyMap = make(map[string]int64)
+	ret.hostNextMap = make(map[string]time.Time)
+	ret.slotMap = make(map[string]*JobSlot)
 	ret.terminate = false
 
 	return ret
@@ -101,35 +232,58 @@ func JobMaster(queueChannel chan string, scoreboard *Scoreboard) {
 	scoreboard.Lock.RLock()
 	queueDir := scoreboard.Conf.QueueDir
 	maxProc := scoreboard.Conf.MaxProc
+	jobConf := *scoreboard.Conf
 	scoreboard.Lock.RUnlock()
 
 	log.Printf("job master starts")
+	// no slave runs yet, every job left in the run directory is orphaned.
+	RecoverRunJobs(&jobConf, 0)
+	lastRecover := time.Now()
 	for idx := procCnt; idx < maxProc; idx++ {
 		go JobSlave(queueChannel, scoreboard)
 	}
 
+	waitDir := ppqueue.GetJobWaitDir(queueDir)
+	hostCache := make(map[string]string)
+	watchChannel := ppioutil.WatchDir(waitDir)
+	if nil == watchChannel {
+		log.Printf("watch queue dir unavailable, polling %s\n", waitDir)
+	}
+
 	for {
 		if scoreboard.IsTerminated() {
 			break
 		}
 
-		waitDir := ppqueue.GetJobWaitDir(queueDir)
-		if dirHandle, err := os.Open(waitDir); nil == err {
-			for {
-				fileList, err := dirHandle.Readdir(1)
+		if RECOVER_INTERVAL <= time.Since(lastRecover) {
+			RecoverRunJobs(&jobConf, jobConf.RunLease)
+			lastRecover = time.Now()
+		}
 
-				if nil != err || 0 >= len(fileList) {
-					break
-				}
+		nextNotBefore := DispatchJobs(queueChannel, scoreboard, waitDir, hostCache)
 
-				queueFile := waitDir + string(os.PathSeparator) + fileList[0].Name()
-				queueChannel <- queueFile
+		// new jobs are announced by the watch, finished jobs by a free slot.
+		// rescan now and then anyway, and when a delayed job becomes eligible or
+		// a busy host may start a job.
+		waitDuration := RESCAN_INTERVAL
+		if nil == watchChannel {
+			waitDuration = POLL_INTERVAL
+		}
+		if untilNotBefore := time.Until(time.Unix(nextNotBefore, 0)); 0 < nextNotBefore && untilNotBefore < waitDuration {
+			waitDuration = untilNotBefore
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case _, watchValid := <-watchChannel:
+			if !watchValid {
+				log.Printf("watch queue dir stops, polling %s\n", waitDir)
+				watchChannel = nil
 			}
-			dirHandle.Close()
-		} else {
-			log.Printf("open queue dir error - %s", err)
+		case <-scoreboard.SlotChannel:
+		case <-timer.C:
 		}
-		time.Sleep(time.Second)
+		timer.Stop()
 	}
 
 	close(queueChannel)
@@ -137,6 +291,63 @@ func JobMaster(queueChannel chan string, scoreboard *Scoreboard) {
 	log.Printf("master stops\n")
 }
 
+// DispatchJobs hands the eligible jobs of waitDir to the slaves, higher
+// priorities first, as long as slaves are available for their queues and
+// their hosts are not busy. Jobs of busy hosts are left for a later
+// dispatch. It returns the earliest time at which a job not yet eligible
+// becomes eligible or a busy host may start a job, or 0 if there is none.
+// hostCache keeps the host of each job, so that a job is read only once.
+func DispatchJobs(queueChannel chan string, scoreboard *Scoreboard, waitDir string, hostCache map[string]string) int64 {
+	ret := int64(0)
+	timestamp := time.Now().Unix()
+	jobList := make(ppqueue.JobFileInfoList, 0)
+	jobNameMap := make(map[string]bool)
+
+	for _, jobName := range ppqueue.ListJobDir(waitDir) {
+		jobNameMap[jobName] = true
+		jobFileInfo := ppqueue.ParseJobFileName(jobName)
+		if timestamp >= jobFileInfo.NotBefore {
+			jobList = append(jobList, jobFileInfo)
+		} else if 0 == ret || jobFileInfo.NotBefore < ret {
+			ret = jobFileInfo.NotBefore
+		}
+	}
+	sort.Sort(jobList)
+
+	for jobName := range hostCache {
+		if !jobNameMap[jobName] {
+			delete(hostCache, jobName)
+		}
+	}
+	scoreboard.PruneHosts()
+
+	for _, jobFileInfo := range jobList {
+		if scoreboard.IsTerminated() {
+			break
+		}
+
+		jobPath := waitDir + string(os.PathSeparator) + jobFileInfo.Name
+		host, hostOk := hostCache[jobFileInfo.Name]
+		if !hostOk {
+			jobInfo := ppqueue.ReadJob(jobPath)
+			if nil == jobInfo {
+				continue
+			}
+			host = ppstrutil.URL2Host(jobInfo[ppqueue.URL])
+			hostCache[jobFileInfo.Name] = host
+		}
+
+		hostConf := scoreboard.Conf.GetHostConf(host)
+		if scoreboard.AcquireSlot(jobFileInfo, hostConf) {
+			queueChannel <- jobPath
+		} else if hostNext := scoreboard.GetHostNextStart(hostConf.Domain); 0 < hostNext && (0 == ret || hostNext < ret) {
+			ret = hostNext
+		}
+	}
+
+	return ret
+}
+
 func JobSlave(queueChannel chan string, scoreboard *Scoreboard) {
 	if !scoreboard.IncrProcCnt() {
 		return
@@ -144,9 +355,7 @@ func JobSlave(queueChannel chan string, scoreboard *Scoreboard) {
 
 	scoreboard.Lock.RLock()
 	queueDir := scoreboard.Conf.QueueDir
-	phantomJSBin := scoreboard.Conf.PhantomJSBin
-	jsPath := scoreboard.Conf.JS
-	expire := scoreboard.Conf.Expire
+	jobConf := *scoreboard.Conf
 	scoreboard.Lock.RUnlock()
 
 	log.Printf("job slave starts")
@@ -167,32 +376,229 @@ func JobSlave(queueChannel chan string, scoreboard *Scoreboard) {
 				queueFileName := string(queueFile[sepIdx+1:])
 				runFile := runDir + string(os.PathSeparator) + queueFileName
 
+				// the lease of the job starts before it is moved into the run
+				// directory, so that recovery never sees it orphaned.
+				now := time.Now()
+				os.Chtimes(queueFile, now, now)
 				if err := os.Rename(queueFile, runFile); nil == err {
-					timestamp := time.Now().Unix()
 					if jobInfo := ppqueue.ReadJob(runFile); nil != jobInfo {
-						if fileStat, statErr := os.Stat(jobInfo[ppqueue.TARGET_FILE]); (nil != statErr && os.IsNotExist(statErr)) || (nil == statErr && expire < (timestamp-fileStat.ModTime().Unix())) {
-							log.Printf("process job %s for %s\n", runFile, jobInfo[ppqueue.TARGET_FILE])
-							log.Printf("process job %s begins\n", runFile)
-							cmd := exec.Command(phantomJSBin, jsPath, jobInfo[ppqueue.URL], jobInfo[ppqueue.TARGET_FILE], jobInfo[ppqueue.LOG_FILE], jobInfo[ppqueue.USER_AGENT])
-							log.Printf("process job %s ends\n", runFile)
-							if err := cmd.Run(); nil != err {
-								log.Printf("process job err - %s\n", err.Error())
-							}
-						}
+						ProcessJob(runFile, jobInfo, &jobConf)
 					}
 
 					os.Remove(runFile)
 				}
+				scoreboard.ReleaseSlot(queueFileName)
 			}
 		case <-t.C:
 		}
-		time.Sleep(time.Second)
 	}
 
 	scoreboard.DecrProcCnt()
 	log.Printf("slave stops\n")
 }
 
+// ProcessJob renders the screenshot of the job unless a fresh one exists,
+// and records the job state transitions in the state file of the job. The
+// in-flight mark of the job is removed once the job is done for good.
+func ProcessJob(runFile string, jobInfo map[string]string, jobConf *ppconf.PuppeteerConf) {
+	statePath := jobInfo[ppqueue.STATE_FILE]
+	timestamp := time.Now().Unix()
+
+	if ppqueue.IsCancelled(jobConf.QueueDir, jobInfo[ppqueue.KEY]) {
+		FinishCancelledJob(jobInfo, jobConf)
+		return
+	}
+
+	// a screenshot taken since a delayed job became due makes the job
+	// redundant, other jobs are satisfied by a screenshot within their
+	// maximum age.
+	freshSince := timestamp - ppqueue.GetJobMaxAge(jobInfo, jobConf.Expire)
+	if notBefore, err := strconv.ParseInt(jobInfo[ppqueue.NOT_BEFORE], 10, 64); nil == err && freshSince < notBefore {
+		freshSince = notBefore
+	}
+
+	fileStat, statErr := os.Stat(jobInfo[ppqueue.TARGET_FILE])
+	if nil == statErr && freshSince <= fileStat.ModTime().Unix() {
+		pppool.UpdateStateFile(statePath, map[string]string{pppool.STATE: pppool.STATE_READY})
+		ppqueue.RemoveInflight(jobConf.QueueDir, jobInfo[ppqueue.KEY])
+		ppjob.Complete(jobConf, jobInfo)
+		return
+	}
+
+	if nil != statErr && !os.IsNotExist(statErr) {
+		log.Printf("stat job target err - %s\n", statErr.Error())
+		pppool.UpdateStateFile(statePath, map[string]string{pppool.STATE: pppool.STATE_FAILED, pppool.REASON: pppool.REASON_ERR})
+		ppqueue.RemoveInflight(jobConf.QueueDir, jobInfo[ppqueue.KEY])
+		ppjob.Complete(jobConf, jobInfo)
+		return
+	}
+
+	attempts, _ := strconv.ParseInt(jobInfo[ppqueue.ATTEMPTS], 10, 64)
+	attempts++
+	jobInfo[ppqueue.ATTEMPTS] = strconv.FormatInt(attempts, 10)
+	pppool.UpdateStateFile(statePath, map[string]string{
+		pppool.STATE:        pppool.STATE_RUNNING,
+		pppool.ATTEMPTS:     jobInfo[ppqueue.ATTEMPTS],
+		pppool.REASON:       "",
+		pppool.EXIT_CODE:    "",
+		pppool.WAIT_OUTCOME: "",
+		pppool.NOT_BEFORE:   ""})
+
+	log.Printf("process job %s for %s\n", runFile, jobInfo[ppqueue.TARGET_FILE])
+	log.Printf("process job %s begins\n", runFile)
+	// the shard may have been removed by the gc since the job was queued.
+	os.MkdirAll(filepath.Dir(jobInfo[ppqueue.TARGET_FILE]), ppioutil.DIR_MASK)
+	// the screenshot is replaced only once the render succeeds.
+	renderFile := pppool.GetRenderPath(jobInfo[ppqueue.TARGET_FILE])
+	cmdArgs := []string{jobConf.JS, jobInfo[ppqueue.URL], renderFile, jobInfo[ppqueue.LOG_FILE], jobInfo[ppqueue.USER_AGENT]}
+	cmdArgs = append(cmdArgs, ppqueue.GetRenderArgs(jobInfo)...)
+	cmd := exec.Command(jobConf.PhantomJSBin, cmdArgs...)
+	cmdOutput := bytes.NewBufferString("")
+	cmd.Stdout = cmdOutput
+	stopChannel, cancelChannel := WatchRunJob(runFile, jobConf.QueueDir, jobInfo[ppqueue.KEY])
+	cmdResult, err := RunJobCmd(cmd, GetJobTimeout(jobInfo, jobConf.JobTimeout), cancelChannel)
+	close(stopChannel)
+	log.Printf("process job %s ends\n", runFile)
+
+	if CMD_CANCELLED == cmdResult {
+		os.Remove(renderFile)
+		FinishCancelledJob(jobInfo, jobConf)
+		return
+	}
+
+	stateUpdate := GetJobResult(cmdOutput.String(), err, CMD_TIMEOUT == cmdResult)
+	if pppool.STATE_READY == stateUpdate[pppool.STATE] {
+		if pppool.CommitRender(jobInfo[ppqueue.TARGET_FILE], GetKeepVersions(jobInfo, jobConf)) {
+			CheckChange(jobInfo, jobConf)
+		} else {
+			log.Printf("commit render %s failed\n", renderFile)
+			stateUpdate[pppool.STATE] = pppool.STATE_FAILED
+			stateUpdate[pppool.REASON] = pppool.REASON_ERR
+		}
+	}
+	os.Remove(renderFile)
+	if pppool.STATE_FAILED == stateUpdate[pppool.STATE] && pppool.REASON_NO_MATCH != stateUpdate[pppool.REASON] {
+		if attempts < jobConf.MaxAttempts {
+			notBefore := time.Now().Unix() + GetRetryBackoff(attempts, jobConf)
+			if ppqueue.WriteJobNotBefore(jobConf.QueueDir, jobInfo, notBefore) {
+				log.Printf("retry job %s after %d\n", runFile, notBefore)
+				stateUpdate[pppool.STATE] = pppool.STATE_QUEUED
+				stateUpdate[pppool.NOT_BEFORE] = strconv.FormatInt(notBefore, 10)
+			}
+		} else {
+			jobInfo[ppqueue.FAIL_REASON] = stateUpdate[pppool.REASON]
+			if ppqueue.WriteDeadJob(jobConf.QueueDir, jobInfo) {
+				log.Printf("job %s is dead after %d attempts\n", runFile, attempts)
+			}
+		}
+	}
+
+	pppool.UpdateStateFile(statePath, stateUpdate)
+	if pppool.STATE_QUEUED != stateUpdate[pppool.STATE] {
+		ppqueue.RemoveInflight(jobConf.QueueDir, jobInfo[ppqueue.KEY])
+		ppjob.Complete(jobConf, jobInfo)
+	}
+}
+
+// GetRetryBackoff returns the seconds to wait before the next attempt of a
+// job, doubling with each attempt up to the configured cap.
+func GetRetryBackoff(attempts int64, jobConf *ppconf.PuppeteerConf) int64 {
+	ret := jobConf.BackoffBase
+
+	for idx := int64(1); idx < attempts && ret < jobConf.BackoffCap; idx++ {
+		ret *= 2
+	}
+
+	if jobConf.BackoffCap < ret {
+		ret = jobConf.BackoffCap
+	}
+
+	return ret
+}
+
+// GetJobTimeout returns the timeout given in the job, or the configured
+// default if the job has none.
+func GetJobTimeout(jobInfo map[string]string, defaultTimeout int64) time.Duration {
+	ret := defaultTimeout
+
+	if timeout, err := strconv.ParseInt(jobInfo[ppqueue.TIMEOUT], 10, 64); nil == err && 0 < timeout {
+		ret = timeout
+	}
+
+	return time.Duration(ret) * time.Second
+}
+
+// FinishCancelledJob records the job as cancelled and drops its
+// in-flight and cancel marks.
+func FinishCancelledJob(jobInfo map[string]string, jobConf *ppconf.PuppeteerConf) {
+	log.Printf("job of %s is cancelled\n", jobInfo[ppqueue.KEY])
+	pppool.UpdateStateFile(jobInfo[ppqueue.STATE_FILE], map[string]string{pppool.STATE: pppool.STATE_CANCELLED})
+	ppqueue.RemoveInflight(jobConf.QueueDir, jobInfo[ppqueue.KEY])
+	ppqueue.RemoveCancel(jobConf.QueueDir, jobInfo[ppqueue.KEY])
+	ppjob.Complete(jobConf, jobInfo)
+}
+
+// RunJobCmd runs cmd in a process group of its own and kills the whole
+// group once timeout is exceeded or cancelChannel is closed, so that hung
+// phantomjs processes and their children do not pin the worker.
+func RunJobCmd(cmd *exec.Cmd, timeout time.Duration, cancelChannel chan bool) (int, error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); nil != err {
+		return CMD_DONE, err
+	}
+
+	doneChannel := make(chan error, 1)
+	go func() {
+		doneChannel <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-doneChannel:
+		return CMD_DONE, err
+	case <-timer.C:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		return CMD_TIMEOUT, <-doneChannel
+	case <-cancelChannel:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		return CMD_CANCELLED, <-doneChannel
+	}
+}
+
+// GetJobResult returns the ready or failed state update according to the
+// outcome of the render script. A WaitOutcome=Value line printed by the
+// script is kept in the state, other output is ignored.
+func GetJobResult(cmdOutput string, runErr error, timedOut bool) map[string]string {
+	stateUpdate := make(map[string]string)
+	for _, line := range strings.Split(cmdOutput, "\n") {
+		if equalIdx := strings.Index(line, "="); 0 < equalIdx && pppool.WAIT_OUTCOME == line[0:equalIdx] {
+			stateUpdate[pppool.WAIT_OUTCOME] = strings.TrimSpace(line[equalIdx+1:])
+		}
+	}
+	stateUpdate[pppool.STATE] = pppool.STATE_READY
+	stateUpdate[pppool.EXIT_CODE] = "0"
+
+	if nil != runErr {
+		log.Printf("process job err - %s\n", runErr.Error())
+		stateUpdate[pppool.STATE] = pppool.STATE_FAILED
+		stateUpdate[pppool.REASON] = pppool.REASON_ERR
+		stateUpdate[pppool.EXIT_CODE] = "-1"
+		if exitErr, ok := runErr.(*exec.ExitError); ok {
+			stateUpdate[pppool.EXIT_CODE] = strconv.Itoa(exitErr.ExitCode())
+			if JS_EXIT_NO_MATCH == exitErr.ExitCode() {
+				stateUpdate[pppool.REASON] = pppool.REASON_NO_MATCH
+			}
+		}
+		if timedOut {
+			stateUpdate[pppool.REASON] = pppool.REASON_TIMEOUT
+		}
+	}
+
+	return stateUpdate
+}
+
 func main() {
 	if 2 > len(os.Args) {
 		Usage()
@@ -203,6 +609,13 @@ func main() {
 		Usage()
 	}
 
+	if 2 < len(os.Args) {
+		if !RunCommand(puppeteerConf, os.Args[2:]) {
+			Usage()
+		}
+		os.Exit(0)
+	}
+
 	if logFH, logErr := os.OpenFile(puppeteerConf.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, ppioutil.FILE_MASK); nil == logErr {
 		log.SetOutput(logFH)
 		log.SetFlags(log.LstdFlags)
@@ -212,6 +625,9 @@ func main() {
 	scoreboard := NewScoreboard(puppeteerConf)
 
 	go JobMaster(queueChannel, scoreboard)
+	go Scheduler(scoreboard)
+	go GarbageCollector(scoreboard)
+	go Notifier(scoreboard)
 	time.Sleep(time.Second)
 
 	signalChannel := make(chan os.Signal, 1)
@@ -241,9 +657,14 @@ func main() {
 
 func Usage() {
 	usageStrFmt := `
-    %s [puppeteer.conf]
+    %s [puppeteer.conf] [command]
 
     puppeteer.conf: configuration of puppeteer.
+    command: optional. one-shot command to run instead of the daemon.
+        dead: list jobs which exhausted their retries.
+        replay [job ...]: queue the given dead jobs again, all if none given.
+        gc: remove the pool files older than Retention, and evict the least
+            recently used screenshots beyond PoolMaxBytes.
 `
 	usageStr := fmt.Sprintf(usageStrFmt, os.Args[0])
 	fmt.Print(usageStr)
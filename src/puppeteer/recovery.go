@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"os"
+	ppconf "puppeteerlib/conf"
+	ppjob "puppeteerlib/job"
+	pppool "puppeteerlib/pool"
+	ppqueue "puppeteerlib/queue"
+	"strconv"
+	"time"
+)
+
+const (
+	HEARTBEAT_INTERVAL    = 10 * time.Second
+	CANCEL_CHECK_INTERVAL = 500 * time.Millisecond
+	RECOVER_INTERVAL      = time.Minute
+)
+
+// WatchRunJob refreshes the modification time of runFile until the
+// returned stop channel is closed. A run file whose modification time is
+// older than the run lease is considered orphaned. The returned cancel
+// channel is closed once the job of key is asked to be cancelled.
+func WatchRunJob(runFile string, queueDir string, key string) (chan bool, chan bool) {
+	stopChannel := make(chan bool)
+	cancelChannel := make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(CANCEL_CHECK_INTERVAL)
+		defer ticker.Stop()
+		lastHeartbeat := time.Now()
+		cancelled := false
+
+		for {
+			select {
+			case <-stopChannel:
+				return
+			case now := <-ticker.C:
+				if HEARTBEAT_INTERVAL <= now.Sub(lastHeartbeat) {
+					os.Chtimes(runFile, now, now)
+					lastHeartbeat = now
+				}
+
+				if !cancelled && ppqueue.IsCancelled(queueDir, key) {
+					cancelled = true
+					close(cancelChannel)
+				}
+			}
+		}
+	}()
+
+	return stopChannel, cancelChannel
+}
+
+// RecoverRunJobs hands jobs left in the run directory by a dead slave or
+// daemon back to the wait queue, or to the dead queue if they exhausted
+// their retries. Only jobs without heartbeat for lease seconds are
+// recovered, thus lease 0 recovers every job in the run directory.
+func RecoverRunJobs(jobConf *ppconf.PuppeteerConf, lease int64) {
+	runDir := ppqueue.GetJobRunDir(jobConf.QueueDir)
+	timestamp := time.Now().Unix()
+
+	for _, jobName := range ppqueue.ListJobDir(runDir) {
+		runFile := runDir + string(os.PathSeparator) + jobName
+		fileStat, err := os.Stat(runFile)
+		if nil != err || lease > (timestamp-fileStat.ModTime().Unix()) {
+			continue
+		}
+
+		jobInfo := ppqueue.ReadJob(runFile)
+		if nil == jobInfo {
+			os.Remove(runFile)
+			continue
+		}
+
+		if ppqueue.IsCancelled(jobConf.QueueDir, jobInfo[ppqueue.KEY]) {
+			FinishCancelledJob(jobInfo, jobConf)
+			os.Remove(runFile)
+			continue
+		}
+
+		attempts, _ := strconv.ParseInt(jobInfo[ppqueue.ATTEMPTS], 10, 64)
+		attempts++
+		jobInfo[ppqueue.ATTEMPTS] = strconv.FormatInt(attempts, 10)
+		stateUpdate := map[string]string{
+			pppool.STATE:    pppool.STATE_QUEUED,
+			pppool.REASON:   pppool.REASON_ORPHANED,
+			pppool.ATTEMPTS: jobInfo[ppqueue.ATTEMPTS]}
+
+		recovered := false
+		if attempts < jobConf.MaxAttempts {
+			recovered = ppqueue.WriteJob(jobConf.QueueDir, jobInfo)
+		} else {
+			jobInfo[ppqueue.FAIL_REASON] = pppool.REASON_ORPHANED
+			stateUpdate[pppool.STATE] = pppool.STATE_FAILED
+			recovered = ppqueue.WriteDeadJob(jobConf.QueueDir, jobInfo)
+			if recovered {
+				ppqueue.RemoveInflight(jobConf.QueueDir, jobInfo[ppqueue.KEY])
+			}
+		}
+
+		if recovered {
+			os.Remove(runFile)
+			pppool.UpdateStateFile(jobInfo[ppqueue.STATE_FILE], stateUpdate)
+			log.Printf("recover orphaned job %s as %s\n", runFile, stateUpdate[pppool.STATE])
+			if pppool.STATE_FAILED == stateUpdate[pppool.STATE] {
+				ppjob.Complete(jobConf, jobInfo)
+			}
+		}
+	}
+}
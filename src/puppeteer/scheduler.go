@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	ppconf "puppeteerlib/conf"
+	ppioutil "puppeteerlib/ioutil"
+	ppjob "puppeteerlib/job"
+	ppqueue "puppeteerlib/queue"
+	ppschedule "puppeteerlib/schedule"
+	"strconv"
+	"time"
+)
+
+const (
+	SCHEDULE_RESCAN_INTERVAL = time.Minute
+)
+
+var scheduleResultMap = map[int]string{
+	ppjob.SUBMIT_QUEUED:    ppschedule.RESULT_QUEUED,
+	ppjob.SUBMIT_COALESCED: ppschedule.RESULT_COALESCED,
+	ppjob.SUBMIT_ERR:       ppschedule.RESULT_ERR}
+
+// Scheduler submits the jobs of the schedules as they become due. The
+// schedules and their next run times are kept on disk, so schedules
+// resume after a restart, and runs missed while down are run once.
+func Scheduler(scoreboard *Scoreboard) {
+	scoreboard.Lock.RLock()
+	jobConf := *scoreboard.Conf
+	scoreboard.Lock.RUnlock()
+
+	log.Printf("scheduler starts")
+	scheduleDir := ppschedule.GetScheduleDir(jobConf.QueueDir)
+	watchChannel := ppioutil.WatchDir(scheduleDir)
+
+	for {
+		if scoreboard.IsTerminated() {
+			break
+		}
+
+		nextRun := RunSchedules(&jobConf)
+
+		// new schedules are announced by the watch.
+		waitDuration := SCHEDULE_RESCAN_INTERVAL
+		if nil == watchChannel {
+			waitDuration = RESCAN_INTERVAL
+		}
+		if untilNextRun := time.Until(time.Unix(nextRun, 0)); 0 < nextRun && untilNextRun < waitDuration {
+			waitDuration = untilNextRun
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case _, watchValid := <-watchChannel:
+			if !watchValid {
+				watchChannel = nil
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	log.Printf("scheduler stops\n")
+}
+
+// RunSchedules submits the jobs of the due schedules, and returns the
+// earliest next run of all schedules, or 0 if there is none.
+func RunSchedules(jobConf *ppconf.PuppeteerConf) int64 {
+	ret := int64(0)
+	queueDir := jobConf.QueueDir
+
+	for _, schedule := range ppschedule.ListSchedules(queueDir) {
+		now := time.Now().Unix()
+
+		if schedule.IsDue(now) {
+			// due now, so that an earlier screenshot does not satisfy the job.
+			jobControl := map[string]string{ppqueue.NOT_BEFORE: strconv.FormatInt(now, 10)}
+			for ctrlName, ctrlVal := range schedule.JobControl {
+				jobControl[ctrlName] = ctrlVal
+			}
+
+			screenshotInfo, submitResult := ppjob.Submit(jobConf, schedule.URL, schedule.UserAgent, schedule.JobOptions, jobControl)
+			scheduleRun := ppschedule.ScheduleRun{Time: now, Result: scheduleResultMap[submitResult]}
+			if nil != screenshotInfo {
+				scheduleRun.Key = screenshotInfo.Fingerprint
+			}
+
+			schedule.Advance(now)
+			if !ppschedule.UpdateSchedule(queueDir, schedule) {
+				continue
+			}
+			ppschedule.AppendHistory(queueDir, schedule.ID, scheduleRun)
+			log.Printf("schedule %s submits %s: %s\n", schedule.ID, scheduleRun.Key, scheduleRun.Result)
+		}
+
+		if 0 < schedule.NextRun && (0 == ret || schedule.NextRun < ret) {
+			ret = schedule.NextRun
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,134 @@
+package batch
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	ppioutil "puppeteerlib/ioutil"
+	ppqueue "puppeteerlib/queue"
+	"strings"
+)
+
+const (
+	BATCH_DIR = "batch"
+	ID_LEN    = 32
+	ITEM_SEP  = "\t"
+)
+
+// BatchItem is an item of a batch, the key of its job, or the error which
+// kept it from being queued.
+type BatchItem struct {
+	Key   string
+	Error string
+}
+
+func GetBatchDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + BATCH_DIR
+	return ret
+}
+
+func getBatchPath(queueDir string, id string) string {
+	return GetBatchDir(queueDir) + string(os.PathSeparator) + id
+}
+
+// IsValidID tells whether id looks like an id returned by NewID, so that
+// it is safe to be used as a file name.
+func IsValidID(id string) bool {
+	if ID_LEN != len(id) {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+
+	return nil == err
+}
+
+// NewID returns a random id of ID_LEN hex digits, or an empty string if
+// no random bytes are available.
+func NewID() string {
+	idBytes := make([]byte, ID_LEN/2)
+	if _, err := rand.Read(idBytes); nil != err {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", idBytes)
+}
+
+// WriteBatch keeps the items of the batch of id, one "key\terror" line per
+// item in submission order.
+func WriteBatch(queueDir string, id string, itemList []BatchItem) bool {
+	batchPath := getBatchPath(queueDir, id)
+	fileHandle, err := os.OpenFile(batchPath+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, ppioutil.FILE_MASK)
+	if nil != err {
+		return false
+	}
+
+	writer := bufio.NewWriter(fileHandle)
+	for _, item := range itemList {
+		fmt.Fprintf(writer, "%s%s%s\n", item.Key, ITEM_SEP, item.Error)
+	}
+	err = writer.Flush()
+	fileHandle.Close()
+
+	if nil == err {
+		err = os.Rename(batchPath+".tmp", batchPath)
+	}
+
+	if nil != err {
+		os.Remove(batchPath + ".tmp")
+		return false
+	}
+
+	return true
+}
+
+// ReadBatch returns the items of the batch of id and when it was
+// submitted, or nil if there is no such batch.
+func ReadBatch(queueDir string, id string) ([]BatchItem, int64) {
+	if !IsValidID(id) {
+		return nil, 0
+	}
+
+	fileHandle, err := os.Open(getBatchPath(queueDir, id))
+	if nil != err {
+		return nil, 0
+	}
+	defer fileHandle.Close()
+
+	fileStat, err := fileHandle.Stat()
+	if nil != err {
+		return nil, 0
+	}
+
+	ret := make([]BatchItem, 0)
+	scanner := bufio.NewScanner(fileHandle)
+	for scanner.Scan() {
+		partList := strings.SplitN(scanner.Text(), ITEM_SEP, 2)
+		if 2 == len(partList) {
+			ret = append(ret, BatchItem{Key: partList[0], Error: partList[1]})
+		}
+	}
+
+	return ret, fileStat.ModTime().Unix()
+}
+
+// RemoveBatches removes the batches submitted before deadline, and returns
+// how many were removed.
+func RemoveBatches(queueDir string, deadline int64) int64 {
+	ret := int64(0)
+
+	for _, fileName := range ppqueue.ListJobDir(GetBatchDir(queueDir)) {
+		batchPath := getBatchPath(queueDir, fileName)
+		if fileStat, err := os.Stat(batchPath); nil != err || deadline <= fileStat.ModTime().Unix() {
+			continue
+		}
+
+		if err := os.Remove(batchPath); nil == err {
+			ret++
+		}
+	}
+
+	return ret
+}
@@ -2,9 +2,13 @@ package conf
 
 import (
 	"os"
+	ppbatch "puppeteerlib/batch"
 	ppioutil "puppeteerlib/ioutil"
+	ppnotify "puppeteerlib/notify"
 	ppqueue "puppeteerlib/queue"
+	ppschedule "puppeteerlib/schedule"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -16,8 +20,61 @@ const (
 	LOG_FILE       = "LogFile"
 	EXPIRE         = "Expire"
 	EXPIRE_DEFAULT = int64(7200)
+	JOB_TIMEOUT    = "JobTimeout"
+	MAX_ATTEMPTS   = "MaxAttempts"
+	BACKOFF_BASE   = "RetryBackoffBase"
+	BACKOFF_CAP    = "RetryBackoffCap"
+	RUN_LEASE      = "RunLease"
+	QUEUES         = "Queues"
+	QUEUE_PREFIX   = "Queue."
+	QUEUE_PRIORITY = ".Priority"
+	QUEUE_RESERVE  = ".Reserve"
+	HOST_MAX_PROC  = "HostMaxProc"
+	HOST_INTERVAL  = "HostMinInterval"
+	HOST_PREFIX    = "Host."
+	HOST_PROC      = ".MaxProc"
+	HOST_MIN_INTVL = ".MinInterval"
+	RETENTION      = "Retention"
+	GC_INTERVAL    = "GCInterval"
+	BATCH_RETAIN   = "BatchRetention"
+	POOL_MAX_BYTES = "PoolMaxBytes"
+	KEEP_VERSIONS  = "KeepVersions"
+	WEBHOOK_SECRET = "WebhookSecret"
+	NOTIFY_MAX_ATT = "NotifyMaxAttempts"
+	NOTIFY_TIMEOUT = "NotifyTimeout"
+	CAPTURE_TMOUT  = "CaptureTimeout"
+
+	JOB_TIMEOUT_DEFAULT    = int64(120)
+	MAX_ATTEMPTS_DEFAULT   = int64(3)
+	BACKOFF_BASE_DEFAULT   = int64(10)
+	BACKOFF_CAP_DEFAULT    = int64(600)
+	RUN_LEASE_DEFAULT      = int64(60)
+	GC_INTERVAL_DEFAULT    = int64(3600)
+	BATCH_RETAIN_DEFAULT   = int64(604800)
+	KEEP_VERSIONS_DEFAULT  = int64(1)
+	NOTIFY_MAX_ATT_DEFAULT = int64(5)
+	NOTIFY_TIMEOUT_DEFAULT = int64(10)
+	CAPTURE_TMOUT_DEFAULT  = int64(30)
 )
 
+// QueueConf is a named queue. Jobs of the queue get Priority unless they
+// are submitted with a priority of their own, and Reserve workers are kept
+// free for the queue.
+type QueueConf struct {
+	Name     string
+	Priority int64
+	Reserve  int64
+}
+
+// HostConf limits the jobs of Domain and its subdomains to MaxProc
+// renders at a time, started at least MinInterval seconds apart. Zero
+// means no limit.
+type HostConf struct {
+	Domain      string
+	MaxProc     int64
+	MinInterval int64
+}
+
 type PuppeteerConf struct {
 	PoolDir      string
 	QueueDir     string
@@ -26,6 +83,27 @@ type PuppeteerConf struct {
 	LogFile      string
 	MaxProc      uint8
 	Expire       int64
+	JobTimeout   int64
+	MaxAttempts  int64
+	BackoffBase  int64
+	BackoffCap   int64
+	RunLease     int64
+	QueueList    []QueueConf
+	HostDefault  HostConf
+	HostList     []HostConf
+	Retention    int64
+	GCInterval   int64
+	// BatchRetention is kept apart from Retention, batches pile up even
+	// if the pool is kept forever.
+	BatchRetention int64
+	PoolMaxBytes   int64
+	KeepVersions   int64
+	// WebhookSecret signs the notifications of alerts without a secret of
+	// their own.
+	WebhookSecret     string
+	NotifyMaxAttempts int64
+	NotifyTimeout     int64
+	CaptureTimeout    int64
 }
 
 func LoadPuppeteerConf(confPath string) *PuppeteerConf {
@@ -55,6 +133,28 @@ func LoadPuppeteerConf(confPath string) *PuppeteerConf {
 				if nil == err && 0 < expire {
 					ret.Expire = expire
 				}
+
+				ret.JobTimeout = getPositiveInt(confInfo, JOB_TIMEOUT, JOB_TIMEOUT_DEFAULT)
+				ret.MaxAttempts = getPositiveInt(confInfo, MAX_ATTEMPTS, MAX_ATTEMPTS_DEFAULT)
+				ret.BackoffBase = getPositiveInt(confInfo, BACKOFF_BASE, BACKOFF_BASE_DEFAULT)
+				ret.BackoffCap = getPositiveInt(confInfo, BACKOFF_CAP, BACKOFF_CAP_DEFAULT)
+				ret.RunLease = getPositiveInt(confInfo, RUN_LEASE, RUN_LEASE_DEFAULT)
+				ret.QueueList = loadQueueList(confInfo)
+				ret.HostDefault.MaxProc = getPositiveInt(confInfo, HOST_MAX_PROC, 0)
+				ret.HostDefault.MinInterval = getPositiveInt(confInfo, HOST_INTERVAL, 0)
+				ret.HostList = loadHostList(confInfo, ret.HostDefault)
+				ret.Retention = getPositiveInt(confInfo, RETENTION, 0)
+				ret.GCInterval = getPositiveInt(confInfo, GC_INTERVAL, GC_INTERVAL_DEFAULT)
+				ret.BatchRetention = getPositiveInt(confInfo, BATCH_RETAIN, BATCH_RETAIN_DEFAULT)
+				ret.PoolMaxBytes = getPositiveInt(confInfo, POOL_MAX_BYTES, 0)
+				ret.KeepVersions = getPositiveInt(confInfo, KEEP_VERSIONS, KEEP_VERSIONS_DEFAULT)
+				ret.WebhookSecret = confInfo[WEBHOOK_SECRET]
+				if !ppnotify.IsValidSecret(ret.WebhookSecret) {
+					return nil
+				}
+				ret.NotifyMaxAttempts = getPositiveInt(confInfo, NOTIFY_MAX_ATT, NOTIFY_MAX_ATT_DEFAULT)
+				ret.NotifyTimeout = getPositiveInt(confInfo, NOTIFY_TIMEOUT, NOTIFY_TIMEOUT_DEFAULT)
+				ret.CaptureTimeout = getPositiveInt(confInfo, CAPTURE_TMOUT, CAPTURE_TMOUT_DEFAULT)
 			}
 		}
 	}
@@ -62,6 +162,123 @@ func LoadPuppeteerConf(confPath string) *PuppeteerConf {
 	return ret
 }
 
+// getPositiveInt returns the optional setting name, or defaultVal if it is
+// missing or not a positive integer.
+func getPositiveInt(confInfo map[string]string, name string, defaultVal int64) int64 {
+	if valStr, ok := confInfo[name]; ok {
+		if val, err := strconv.ParseInt(valStr, 10, 64); nil == err && 0 < val {
+			return val
+		}
+	}
+
+	return defaultVal
+}
+
+// loadQueueList returns the queues named in Queues, with their settings
+// given as Queue.<name>.Priority and Queue.<name>.Reserve. The default
+// queue always exists.
+func loadQueueList(confInfo map[string]string) []QueueConf {
+	ret := []QueueConf{}
+	hasDefault := false
+
+	for _, queueName := range strings.Split(confInfo[QUEUES], ",") {
+		queueName = strings.TrimSpace(queueName)
+		if !ppqueue.IsValidQueueName(queueName) {
+			continue
+		}
+
+		queueConf := QueueConf{Name: queueName}
+		queueConf.Priority, _ = strconv.ParseInt(confInfo[QUEUE_PREFIX+queueName+QUEUE_PRIORITY], 10, 64)
+		queueConf.Reserve = getPositiveInt(confInfo, QUEUE_PREFIX+queueName+QUEUE_RESERVE, 0)
+		ret = append(ret, queueConf)
+
+		if ppqueue.DEFAULT_QUEUE == queueName {
+			hasDefault = true
+		}
+	}
+
+	if !hasDefault {
+		ret = append(ret, QueueConf{Name: ppqueue.DEFAULT_QUEUE})
+	}
+
+	return ret
+}
+
+// loadHostList returns the per domain limits given as
+// Host.<domain>.MaxProc and Host.<domain>.MinInterval. Limits not given
+// for a domain are taken from hostDefault.
+func loadHostList(confInfo map[string]string, hostDefault HostConf) []HostConf {
+	ret := []HostConf{}
+	hostMap := make(map[string]*HostConf)
+
+	for name, val := range confInfo {
+		if !strings.HasPrefix(name, HOST_PREFIX) {
+			continue
+		}
+
+		domain := ""
+		limit, err := strconv.ParseInt(val, 10, 64)
+		if nil != err || 0 > limit {
+			continue
+		}
+
+		if strings.HasSuffix(name, HOST_PROC) {
+			domain = strings.TrimSuffix(strings.TrimPrefix(name, HOST_PREFIX), HOST_PROC)
+		} else if strings.HasSuffix(name, HOST_MIN_INTVL) {
+			domain = strings.TrimSuffix(strings.TrimPrefix(name, HOST_PREFIX), HOST_MIN_INTVL)
+		}
+		domain = strings.ToLower(domain)
+		if "" == domain {
+			continue
+		}
+
+		hostConf, ok := hostMap[domain]
+		if !ok {
+			hostConf = &HostConf{Domain: domain, MaxProc: hostDefault.MaxProc, MinInterval: hostDefault.MinInterval}
+			hostMap[domain] = hostConf
+		}
+
+		if strings.HasSuffix(name, HOST_PROC) {
+			hostConf.MaxProc = limit
+		} else {
+			hostConf.MinInterval = limit
+		}
+	}
+
+	for _, hostConf := range hostMap {
+		ret = append(ret, *hostConf)
+	}
+
+	return ret
+}
+
+// GetHostConf returns the limits of host, those of the longest configured
+// domain host belongs to, or the default limits applied to host alone.
+func (this *PuppeteerConf) GetHostConf(host string) HostConf {
+	ret := this.HostDefault
+	ret.Domain = host
+
+	matchLen := 0
+	for _, hostConf := range this.HostList {
+		if matchLen < len(hostConf.Domain) && (host == hostConf.Domain || strings.HasSuffix(host, "."+hostConf.Domain)) {
+			ret = hostConf
+			matchLen = len(hostConf.Domain)
+		}
+	}
+
+	return ret
+}
+
+func (this *PuppeteerConf) GetQueueConf(queueName string) *QueueConf {
+	for idx := range this.QueueList {
+		if queueName == this.QueueList[idx].Name {
+			return &this.QueueList[idx]
+		}
+	}
+
+	return nil
+}
+
 func ChkPuppeteerConf(puppeteerConf *PuppeteerConf) bool {
 	if nil == puppeteerConf {
 		return false
@@ -72,9 +289,21 @@ func ChkPuppeteerConf(puppeteerConf *PuppeteerConf) bool {
 	initDir := ppqueue.GetJobInitDir(puppeteerConf.QueueDir)
 	runDir := ppqueue.GetJobRunDir(puppeteerConf.QueueDir)
 	waitDir := ppqueue.GetJobWaitDir(puppeteerConf.QueueDir)
+	deadDir := ppqueue.GetJobDeadDir(puppeteerConf.QueueDir)
+	inflightDir := ppqueue.GetJobInflightDir(puppeteerConf.QueueDir)
+	cancelDir := ppqueue.GetJobCancelDir(puppeteerConf.QueueDir)
+	scheduleDir := ppschedule.GetScheduleDir(puppeteerConf.QueueDir)
 	os.MkdirAll(initDir, ppioutil.DIR_MASK)
 	os.MkdirAll(runDir, ppioutil.DIR_MASK)
 	os.MkdirAll(waitDir, ppioutil.DIR_MASK)
+	os.MkdirAll(deadDir, ppioutil.DIR_MASK)
+	os.MkdirAll(inflightDir, ppioutil.DIR_MASK)
+	os.MkdirAll(cancelDir, ppioutil.DIR_MASK)
+	os.MkdirAll(ppqueue.GetJobCallbackDir(puppeteerConf.QueueDir), ppioutil.DIR_MASK)
+	os.MkdirAll(scheduleDir, ppioutil.DIR_MASK)
+	os.MkdirAll(ppnotify.GetAlertDir(puppeteerConf.QueueDir), ppioutil.DIR_MASK)
+	os.MkdirAll(ppnotify.GetNotifyDir(puppeteerConf.QueueDir), ppioutil.DIR_MASK)
+	os.MkdirAll(ppbatch.GetBatchDir(puppeteerConf.QueueDir), ppioutil.DIR_MASK)
 
 	if !ppioutil.IsDirExists(puppeteerConf.PoolDir) {
 		return false
@@ -0,0 +1,223 @@
+package diff
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"sort"
+)
+
+const (
+	// pixels are compared in cells of CELL_SIZE, adjacent changed cells
+	// form a region.
+	CELL_SIZE = 16
+	// channels differing by up to THRESHOLD_DEFAULT are taken as unchanged
+	// by default, which hides compression and anti-aliasing noise.
+	THRESHOLD_DEFAULT = 16
+	// at most REGION_MAX regions, the largest ones, are reported.
+	REGION_MAX = 100
+	// unchanged pixels of the diff image are faded toward white by
+	// FADE_RATIO, so that changes stand out.
+	FADE_RATIO = 0.7
+	// screenshots are compared up to PIXEL_MAX pixels, the size of the
+	// larger one taken in either direction. Larger screenshots would take
+	// too much memory, at 4 bytes per pixel for each image.
+	PIXEL_MAX = 25000000
+)
+
+// ErrTooLarge is returned for screenshots beyond PIXEL_MAX pixels.
+var ErrTooLarge = errors.New("image too large to compare")
+
+var highlightColor = color.RGBA{R: 255, A: 255}
+
+// Region is the bounding box of adjacent changed pixels.
+type Region struct {
+	X int
+	Y int
+	W int
+	H int
+}
+
+// DiffResult tells how two screenshots differ. Pixels beyond the bounds of
+// either screenshot count as changed.
+type DiffResult struct {
+	Width        int
+	Height       int
+	ChangedCnt   int64
+	ChangedRatio float64
+	RegionCnt    int
+	Regions      []Region
+	Image        *image.RGBA `json:"-"`
+}
+
+// LoadImage decodes the png or jpeg image at filePath, or returns
+// ErrTooLarge without decoding it if it is beyond PIXEL_MAX pixels.
+func LoadImage(filePath string) (image.Image, error) {
+	fh, err := os.Open(filePath)
+	if nil != err {
+		return nil, err
+	}
+	defer fh.Close()
+
+	imageConf, _, err := image.DecodeConfig(fh)
+	if nil != err {
+		return nil, err
+	}
+	if !isSizeOk(imageConf.Width, imageConf.Height) {
+		return nil, ErrTooLarge
+	}
+
+	if _, err := fh.Seek(0, 0); nil != err {
+		return nil, err
+	}
+	ret, _, err := image.Decode(fh)
+
+	return ret, err
+}
+
+func isSizeOk(width int, height int) bool {
+	return 0 <= width && 0 <= height && (0 == height || PIXEL_MAX/height >= width)
+}
+
+// isPixelChanged tells whether any channel of the two colors differs by
+// more than threshold, on a scale of 0 to 255.
+func isPixelChanged(fromColor color.Color, toColor color.Color, threshold int) bool {
+	fromR, fromG, fromB, fromA := fromColor.RGBA()
+	toR, toG, toB, toA := toColor.RGBA()
+
+	for _, delta := range []int{
+		int(fromR>>8) - int(toR>>8),
+		int(fromG>>8) - int(toG>>8),
+		int(fromB>>8) - int(toB>>8),
+		int(fromA>>8) - int(toA>>8)} {
+		if delta > threshold || -delta > threshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fadeColor(srcColor color.Color) color.RGBA {
+	r, g, b, _ := srcColor.RGBA()
+	fade := func(val uint32) uint8 {
+		return uint8(float64(val>>8) + (255-float64(val>>8))*FADE_RATIO)
+	}
+
+	return color.RGBA{R: fade(r), G: fade(g), B: fade(b), A: 255}
+}
+
+// Compare compares the screenshot to with the earlier screenshot from, or
+// returns nil if the compared area is beyond PIXEL_MAX pixels. The diff
+// image, only drawn if withImage is set, shows to faded, with the changed
+// pixels highlighted.
+func Compare(from image.Image, to image.Image, threshold int, withImage bool) *DiffResult {
+	fromBounds := from.Bounds()
+	toBounds := to.Bounds()
+	width := fromBounds.Dx()
+	if toBounds.Dx() > width {
+		width = toBounds.Dx()
+	}
+	height := fromBounds.Dy()
+	if toBounds.Dy() > height {
+		height = toBounds.Dy()
+	}
+
+	if !isSizeOk(width, height) {
+		return nil
+	}
+
+	ret := &DiffResult{
+		Width:   width,
+		Height:  height,
+		Regions: make([]Region, 0)}
+	if withImage {
+		ret.Image = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+
+	cellCols := (width + CELL_SIZE - 1) / CELL_SIZE
+	cellRows := (height + CELL_SIZE - 1) / CELL_SIZE
+	// bounding box of the changed pixels of each cell, nil if none changed.
+	cellList := make([]*image.Rectangle, cellCols*cellRows)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fromPoint := image.Pt(fromBounds.Min.X+x, fromBounds.Min.Y+y)
+			toPoint := image.Pt(toBounds.Min.X+x, toBounds.Min.Y+y)
+			inFrom := fromPoint.In(fromBounds)
+			inTo := toPoint.In(toBounds)
+
+			if inFrom && inTo && !isPixelChanged(from.At(fromPoint.X, fromPoint.Y), to.At(toPoint.X, toPoint.Y), threshold) {
+				if withImage {
+					ret.Image.SetRGBA(x, y, fadeColor(to.At(toPoint.X, toPoint.Y)))
+				}
+				continue
+			}
+
+			ret.ChangedCnt++
+			if withImage {
+				ret.Image.SetRGBA(x, y, highlightColor)
+			}
+
+			pixelRect := image.Rect(x, y, x+1, y+1)
+			cellIdx := (y/CELL_SIZE)*cellCols + x/CELL_SIZE
+			if nil == cellList[cellIdx] {
+				cellList[cellIdx] = &pixelRect
+			} else {
+				*cellList[cellIdx] = cellList[cellIdx].Union(pixelRect)
+			}
+		}
+	}
+
+	if 0 < width*height {
+		ret.ChangedRatio = float64(ret.ChangedCnt) / float64(width*height)
+	}
+
+	// adjacent changed cells, diagonals included, are merged into regions.
+	visitedList := make([]bool, len(cellList))
+	for startIdx, startRect := range cellList {
+		if nil == startRect || visitedList[startIdx] {
+			continue
+		}
+
+		regionRect := *startRect
+		visitedList[startIdx] = true
+		pendingList := []int{startIdx}
+		for 0 < len(pendingList) {
+			cellIdx := pendingList[len(pendingList)-1]
+			pendingList = pendingList[:len(pendingList)-1]
+			col := cellIdx % cellCols
+			row := cellIdx / cellCols
+
+			for nearRow := row - 1; nearRow <= row+1; nearRow++ {
+				for nearCol := col - 1; nearCol <= col+1; nearCol++ {
+					if 0 > nearRow || cellRows <= nearRow || 0 > nearCol || cellCols <= nearCol {
+						continue
+					}
+
+					nearIdx := nearRow*cellCols + nearCol
+					if nil != cellList[nearIdx] && !visitedList[nearIdx] {
+						visitedList[nearIdx] = true
+						regionRect = regionRect.Union(*cellList[nearIdx])
+						pendingList = append(pendingList, nearIdx)
+					}
+				}
+			}
+		}
+
+		ret.Regions = append(ret.Regions, Region{X: regionRect.Min.X, Y: regionRect.Min.Y, W: regionRect.Dx(), H: regionRect.Dy()})
+	}
+
+	ret.RegionCnt = len(ret.Regions)
+	sort.SliceStable(ret.Regions, func(i, j int) bool {
+		return ret.Regions[i].W*ret.Regions[i].H > ret.Regions[j].W*ret.Regions[j].H
+	})
+	if REGION_MAX < len(ret.Regions) {
+		ret.Regions = ret.Regions[:REGION_MAX]
+	}
+
+	return ret
+}
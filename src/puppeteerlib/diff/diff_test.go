@@ -0,0 +1,139 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func newImage(width int, height int, rectList ...image.Rectangle) *image.RGBA {
+	ret := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(ret, ret.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	for _, rect := range rectList {
+		draw.Draw(ret, rect, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+
+	return ret
+}
+
+func TestCompare(t *testing.T) {
+	noise := newImage(64, 64)
+	noise.SetRGBA(5, 5, color.RGBA{R: 245, G: 245, B: 245, A: 255})
+
+	caseList := []struct {
+		name       string
+		from       image.Image
+		to         image.Image
+		changedCnt int64
+		regionList []Region
+	}{
+		{"identical", newImage(64, 64), newImage(64, 64), 0, []Region{}},
+		{"noise within threshold", newImage(64, 64), noise, 0, []Region{}},
+		{"single pixel", newImage(64, 64), newImage(64, 64, image.Rect(5, 5, 6, 6)), 1, []Region{{5, 5, 1, 1}}},
+		{"adjacent cells merge", newImage(64, 64), newImage(64, 64, image.Rect(15, 0, 17, 1)), 2, []Region{{15, 0, 2, 1}}},
+		{"diagonal cells merge", newImage(64, 64),
+			newImage(64, 64, image.Rect(15, 15, 16, 16), image.Rect(16, 16, 17, 17)), 2, []Region{{15, 15, 2, 2}}},
+		{"distant cells apart, largest first", newImage(64, 64),
+			newImage(64, 64, image.Rect(0, 0, 2, 2), image.Rect(40, 40, 50, 50)), 104, []Region{{40, 40, 10, 10}, {0, 0, 2, 2}}},
+		{"taller screenshot", newImage(32, 32), newImage(32, 48), 32 * 16, []Region{{0, 32, 32, 16}}},
+		{"offset bounds", newImage(64, 64).SubImage(image.Rect(10, 10, 42, 42)), newImage(32, 32), 0, []Region{}},
+	}
+
+	for _, c := range caseList {
+		diffResult := Compare(c.from, c.to, THRESHOLD_DEFAULT, false)
+		if c.changedCnt != diffResult.ChangedCnt {
+			t.Errorf("%s: changed %d, want %d", c.name, diffResult.ChangedCnt, c.changedCnt)
+		}
+		if !reflect.DeepEqual(c.regionList, diffResult.Regions) || len(c.regionList) != diffResult.RegionCnt {
+			t.Errorf("%s: regions %v (%d), want %v", c.name, diffResult.Regions, diffResult.RegionCnt, c.regionList)
+		}
+		if nil != diffResult.Image {
+			t.Errorf("%s: diff image drawn", c.name)
+		}
+	}
+}
+
+func TestCompareImage(t *testing.T) {
+	diffResult := Compare(newImage(32, 32), newImage(32, 32, image.Rect(3, 4, 5, 6)), THRESHOLD_DEFAULT, true)
+
+	if highlightColor != diffResult.Image.RGBAAt(3, 4) {
+		t.Errorf("changed pixel is %v, want %v", diffResult.Image.RGBAAt(3, 4), highlightColor)
+	}
+	if fadeColor(color.White) != diffResult.Image.RGBAAt(0, 0) {
+		t.Errorf("unchanged pixel is %v, want %v", diffResult.Image.RGBAAt(0, 0), fadeColor(color.White))
+	}
+	if 4.0/(32*32) != diffResult.ChangedRatio {
+		t.Errorf("changed ratio %f, want %f", diffResult.ChangedRatio, 4.0/(32*32))
+	}
+}
+
+func TestCompareRegionMax(t *testing.T) {
+	// cells two apart never merge, 11 by 11 of them give 121 regions.
+	rectList := make([]image.Rectangle, 0)
+	for row := 0; row < 11; row++ {
+		for col := 0; col < 11; col++ {
+			rectList = append(rectList, image.Rect(col*2*CELL_SIZE, row*2*CELL_SIZE, col*2*CELL_SIZE+1, row*2*CELL_SIZE+1))
+		}
+	}
+
+	diffResult := Compare(newImage(352, 352), newImage(352, 352, rectList...), THRESHOLD_DEFAULT, false)
+	if 121 != diffResult.RegionCnt || REGION_MAX != len(diffResult.Regions) {
+		t.Errorf("%d regions, %d reported, want 121 and %d", diffResult.RegionCnt, len(diffResult.Regions), REGION_MAX)
+	}
+}
+
+// boundsImage is a uniform image of any size, which takes no memory.
+type boundsImage struct {
+	*image.Uniform
+	bounds image.Rectangle
+}
+
+func (this boundsImage) Bounds() image.Rectangle {
+	return this.bounds
+}
+
+func TestCompareTooLarge(t *testing.T) {
+	// each is within PIXEL_MAX, the compared area is not.
+	wide := boundsImage{image.NewUniform(color.White), image.Rect(0, 0, 6000, 1)}
+	tall := boundsImage{image.NewUniform(color.White), image.Rect(0, 0, 1, 6000)}
+	if diffResult := Compare(wide, tall, THRESHOLD_DEFAULT, false); nil != diffResult {
+		t.Errorf("%dx%d compared", diffResult.Width, diffResult.Height)
+	}
+
+	if nil == Compare(wide, wide, THRESHOLD_DEFAULT, false) {
+		t.Errorf("6000x1 not compared")
+	}
+}
+
+func TestLoadImageTooLarge(t *testing.T) {
+	var buffer bytes.Buffer
+	png.Encode(&buffer, newImage(2, 2))
+	pngBytes := buffer.Bytes()
+
+	// the IHDR chunk follows the 8 bytes signature, its size and type, it
+	// starts with the width and height and ends with a checksum.
+	binary.BigEndian.PutUint32(pngBytes[16:], 6000)
+	binary.BigEndian.PutUint32(pngBytes[20:], 6000)
+	binary.BigEndian.PutUint32(pngBytes[29:], crc32.ChecksumIEEE(pngBytes[12:29]))
+
+	filePath := t.TempDir() + "/large.png"
+	os.WriteFile(filePath, pngBytes, 0644)
+	if _, err := LoadImage(filePath); ErrTooLarge != err {
+		t.Errorf("error %v, want %v", err, ErrTooLarge)
+	}
+
+	filePath = t.TempDir() + "/small.png"
+	buffer.Reset()
+	png.Encode(&buffer, newImage(2, 2))
+	os.WriteFile(filePath, buffer.Bytes(), 0644)
+	if loadedImage, err := LoadImage(filePath); nil != err || 2 != loadedImage.Bounds().Dx() {
+		t.Errorf("2x2 image not loaded: %v", err)
+	}
+}
@@ -3,7 +3,9 @@ package ioutil
 import (
 	"bufio"
 	"bytes"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -93,3 +95,33 @@ func ParseIni(filePath string) (map[string]string, error) {
 
 	return ret, retErr
 }
+
+// WriteIni writes iniInfo as name=value lines to filePath. The content is
+// written to a temporary file first and renamed, so readers never see a
+// partially written file.
+func WriteIni(filePath string, iniInfo map[string]string) bool {
+	fileHandle, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath))
+	if nil != err {
+		return false
+	}
+
+	tempPath := fileHandle.Name()
+	buffer := bytes.NewBufferString("")
+	for name, val := range iniInfo {
+		buffer.WriteString(name + "=" + val + "\n")
+	}
+
+	_, err = fileHandle.Write(buffer.Bytes())
+	fileHandle.Close()
+	if nil == err {
+		os.Chmod(tempPath, FILE_MASK)
+		err = os.Rename(tempPath, filePath)
+	}
+
+	if nil != err {
+		os.Remove(tempPath)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,223 @@
+package ioutil
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	WATCH_BUFFER_SIZE = 4096
+)
+
+// WatchDir returns a channel which is signalled whenever a file is created
+// in or moved into dirPath, or nil if dirPath can not be watched. Events
+// arriving while a signal is pending are merged into it. The channel is
+// closed if watching fails later on.
+func WatchDir(dirPath string) chan bool {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if nil != err {
+		return nil
+	}
+
+	if _, err := syscall.InotifyAddWatch(fd, dirPath, syscall.IN_CREATE|syscall.IN_MOVED_TO); nil != err {
+		syscall.Close(fd)
+		return nil
+	}
+
+	ret := make(chan bool, 1)
+	go func() {
+		buffer := make([]byte, WATCH_BUFFER_SIZE)
+		for {
+			readLen, err := syscall.Read(fd, buffer)
+			if syscall.EINTR == err {
+				continue
+			}
+
+			if nil != err || 0 >= readLen {
+				syscall.Close(fd)
+				close(ret)
+				return
+			}
+
+			select {
+			case ret <- true:
+			default:
+			}
+		}
+	}()
+
+	return ret
+}
+
+// DirWatcher watches directories on demand with a single inotify instance,
+// a directory is watched as long as it has subscribers.
+type DirWatcher struct {
+	fd      int
+	closed  bool
+	lock    sync.Mutex
+	dirMap  map[int32]string
+	wdMap   map[string]int32
+	subsMap map[string]map[chan bool]bool
+}
+
+// NewDirWatcher returns a watcher, or nil if directories can not be
+// watched.
+func NewDirWatcher() *DirWatcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if nil != err {
+		return nil
+	}
+
+	ret := &DirWatcher{fd: fd,
+		dirMap:  make(map[int32]string),
+		wdMap:   make(map[string]int32),
+		subsMap: make(map[string]map[chan bool]bool)}
+	go ret.run()
+
+	return ret
+}
+
+func (this *DirWatcher) run() {
+	buffer := make([]byte, WATCH_BUFFER_SIZE)
+	for {
+		readLen, err := syscall.Read(this.fd, buffer)
+		if syscall.EINTR == err {
+			continue
+		}
+
+		if nil != err || 0 >= readLen {
+			this.closeAll()
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= readLen; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			switch {
+			case 0 != event.Mask&syscall.IN_Q_OVERFLOW:
+				// events were dropped, any directory may have changed.
+				this.signalAll()
+			case 0 != event.Mask&syscall.IN_IGNORED:
+				// the directory was removed, or unsubscribed from.
+				this.drop(event.Wd)
+			default:
+				this.signal(event.Wd)
+			}
+		}
+	}
+}
+
+func notify(subs map[chan bool]bool) {
+	for subChannel := range subs {
+		select {
+		case subChannel <- true:
+		default:
+		}
+	}
+}
+
+func (this *DirWatcher) signal(wd int32) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	notify(this.subsMap[this.dirMap[wd]])
+}
+
+func (this *DirWatcher) signalAll() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, subs := range this.subsMap {
+		notify(subs)
+	}
+}
+
+// drop forgets the watch of wd and closes the channels of its subscribers,
+// which have to subscribe again once the directory exists.
+func (this *DirWatcher) drop(wd int32) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	dirPath, ok := this.dirMap[wd]
+	if !ok {
+		return
+	}
+
+	for subChannel := range this.subsMap[dirPath] {
+		close(subChannel)
+	}
+	delete(this.wdMap, dirPath)
+	delete(this.dirMap, wd)
+	delete(this.subsMap, dirPath)
+}
+
+// closeAll closes the channels of all subscribers once watching failed,
+// further subscriptions fail.
+func (this *DirWatcher) closeAll() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, subs := range this.subsMap {
+		for subChannel := range subs {
+			close(subChannel)
+		}
+	}
+	syscall.Close(this.fd)
+	this.closed = true
+	this.dirMap = make(map[int32]string)
+	this.wdMap = make(map[string]int32)
+	this.subsMap = make(map[string]map[chan bool]bool)
+}
+
+// Subscribe returns a channel which is signalled whenever a file is
+// created in or moved into dirPath, or nil if dirPath can not be watched.
+// Events arriving while a signal is pending are merged into it. The channel
+// is closed once dirPath is removed or watching fails.
+func (this *DirWatcher) Subscribe(dirPath string) chan bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return nil
+	}
+
+	if _, ok := this.wdMap[dirPath]; !ok {
+		wd, err := syscall.InotifyAddWatch(this.fd, dirPath, syscall.IN_CREATE|syscall.IN_MOVED_TO)
+		if nil != err {
+			return nil
+		}
+		this.wdMap[dirPath] = int32(wd)
+		this.dirMap[int32(wd)] = dirPath
+		this.subsMap[dirPath] = make(map[chan bool]bool)
+	}
+
+	ret := make(chan bool, 1)
+	this.subsMap[dirPath][ret] = true
+
+	return ret
+}
+
+// Unsubscribe stops signalling subChannel, and stops watching dirPath once
+// it has no subscribers left.
+func (this *DirWatcher) Unsubscribe(dirPath string, subChannel chan bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	// the channel is closed and forgotten once the watch is dropped, a new
+	// watch of dirPath may have other subscribers.
+	subs := this.subsMap[dirPath]
+	if !subs[subChannel] {
+		return
+	}
+
+	delete(subs, subChannel)
+	if 0 == len(subs) {
+		wd := this.wdMap[dirPath]
+		syscall.InotifyRmWatch(this.fd, uint32(wd))
+		delete(this.wdMap, dirPath)
+		delete(this.dirMap, wd)
+		delete(this.subsMap, dirPath)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package ioutil
+
+// WatchDir is only supported on linux, callers fall back to polling.
+func WatchDir(dirPath string) chan bool {
+	return nil
+}
+
+// DirWatcher is only supported on linux.
+type DirWatcher struct {
+}
+
+// NewDirWatcher returns nil, callers fall back to polling.
+func NewDirWatcher() *DirWatcher {
+	return nil
+}
+
+func (this *DirWatcher) Subscribe(dirPath string) chan bool {
+	return nil
+}
+
+func (this *DirWatcher) Unsubscribe(dirPath string, subChannel chan bool) {
+}
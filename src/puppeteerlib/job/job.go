@@ -0,0 +1,231 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	ppconf "puppeteerlib/conf"
+	ppnotify "puppeteerlib/notify"
+	pppool "puppeteerlib/pool"
+	ppqueue "puppeteerlib/queue"
+	ppstrutil "puppeteerlib/strutil"
+	"strconv"
+	"time"
+)
+
+const (
+	SUBMIT_QUEUED = iota
+	SUBMIT_COALESCED
+	SUBMIT_ERR
+)
+
+const (
+	INFLIGHT_GRACE         = int64(10)
+	INFLIGHT_SETTLE        = 2 * time.Second
+	INFLIGHT_POLL_INTERVAL = 20 * time.Millisecond
+)
+
+// Submit queues a job rendering targetURL with the validated jobOptions
+// and jobControl, unless a job for the same key is queued or running
+// already. It returns the screenshot of the job, with its status set
+// accordingly, and one of the SUBMIT_ results.
+func Submit(puppeteerConf *ppconf.PuppeteerConf, targetURL string, userAgent string, jobOptions map[string]string, jobControl map[string]string) (*pppool.ScreenshotInfo, int) {
+	queueDir := puppeteerConf.QueueDir
+	fingerprint := ppstrutil.URLOptions2Fingerprint(targetURL, jobOptions)
+	screenshotInfo := pppool.GetScreenshotInfoByFingerprint(puppeteerConf.PoolDir, fingerprint)
+
+	if nil == screenshotInfo {
+		return nil, SUBMIT_ERR
+	}
+
+	if !ppqueue.AddInflight(queueDir, fingerprint) && !takeInflight(puppeteerConf, fingerprint) {
+		// the submission holding the mark may not have queued its job yet,
+		// the job is told queued until its state says otherwise.
+		if nextInfo := pppool.GetScreenshotInfoByFingerprint(puppeteerConf.PoolDir, fingerprint); nil != nextInfo {
+			screenshotInfo = nextInfo
+		}
+		if pppool.STAT_QUEUED != screenshotInfo.Status && pppool.STAT_RUNNING != screenshotInfo.Status {
+			screenshotInfo.Status = pppool.STAT_QUEUED
+		}
+		advanceJob(puppeteerConf, screenshotInfo, jobControl)
+		addCallback(puppeteerConf, targetURL, fingerprint, jobControl)
+		return screenshotInfo, SUBMIT_COALESCED
+	}
+
+	// the mark of a delayed job ages from the time the job is due.
+	if notBefore, err := strconv.ParseInt(jobControl[ppqueue.NOT_BEFORE], 10, 64); nil == err {
+		ppqueue.TouchInflight(queueDir, fingerprint, notBefore)
+	}
+
+	if format, formatOk := jobOptions[ppqueue.FORMAT]; formatOk {
+		screenshotInfo.Format = format
+	}
+	ppqueue.RemoveCancel(queueDir, fingerprint)
+	pppool.AppendScreenshotLog(screenshotInfo, fmt.Sprintf("%d\t%s\n", time.Now().Unix(), targetURL))
+	jobData := map[string]string{ppqueue.URL: targetURL,
+		ppqueue.KEY:         fingerprint,
+		ppqueue.TARGET_FILE: pppool.GetScreenshotFilePath(screenshotInfo),
+		ppqueue.LOG_FILE:    pppool.GetScreenshotLogPath(screenshotInfo),
+		ppqueue.STATE_FILE:  pppool.GetScreenshotStatePath(screenshotInfo),
+		ppqueue.USER_AGENT:  userAgent}
+	for optName, optVal := range jobOptions {
+		jobData[optName] = optVal
+	}
+	for ctrlName, ctrlVal := range jobControl {
+		jobData[ctrlName] = ctrlVal
+	}
+
+	statePath := pppool.GetScreenshotStatePath(screenshotInfo)
+	pppool.UpdateStateFile(statePath, map[string]string{
+		pppool.STATE:        pppool.STATE_QUEUED,
+		pppool.ATTEMPTS:     "0",
+		pppool.REASON:       "",
+		pppool.EXIT_CODE:    "",
+		pppool.WAIT_OUTCOME: "",
+		pppool.NOT_BEFORE:   jobControl[ppqueue.NOT_BEFORE]})
+	if !ppqueue.WriteJob(queueDir, jobData) {
+		ppqueue.RemoveInflight(queueDir, fingerprint)
+		pppool.UpdateStateFile(statePath, map[string]string{pppool.STATE: pppool.STATE_FAILED, pppool.REASON: pppool.REASON_ERR})
+		screenshotInfo.Status = pppool.STAT_FAILED
+		screenshotInfo.Reason = pppool.REASON_ERR
+		return screenshotInfo, SUBMIT_ERR
+	}
+
+	screenshotInfo.Status = pppool.STAT_QUEUED
+	screenshotInfo.Attempts = 0
+	screenshotInfo.Reason = ""
+	screenshotInfo.ExitCode = 0
+	screenshotInfo.WaitOutcome = ""
+	screenshotInfo.LastUpdate = 0
+	screenshotInfo.NotBefore, _ = strconv.ParseInt(jobControl[ppqueue.NOT_BEFORE], 10, 64)
+
+	return screenshotInfo, SUBMIT_QUEUED
+}
+
+// takeInflight takes over the in-flight mark of key if it was left behind
+// by a job which is done, that is its state is final and no job of the key
+// waits. The slave removes the mark of a job right after its final state,
+// which is waited for. A mark left behind by a submission which died before
+// queueing its job is taken once it is INFLIGHT_GRACE seconds old, younger
+// ones may belong to a submission still queueing its job.
+func takeInflight(puppeteerConf *ppconf.PuppeteerConf, key string) bool {
+	queueDir := puppeteerConf.QueueDir
+	screenshotInfo := pppool.GetScreenshotInfoByFingerprint(puppeteerConf.PoolDir, key)
+	if nil == screenshotInfo || pppool.STAT_QUEUED == screenshotInfo.Status || pppool.STAT_RUNNING == screenshotInfo.Status {
+		return false
+	}
+
+	if ppqueue.HasWaitJob(queueDir, key) {
+		return false
+	}
+
+	if ppqueue.HasRunJob(queueDir, key) {
+		for deadline := time.Now().Add(INFLIGHT_SETTLE); ppqueue.IsInflight(queueDir, key) && time.Now().Before(deadline); {
+			time.Sleep(INFLIGHT_POLL_INTERVAL)
+		}
+		return ppqueue.AddInflight(queueDir, key)
+	}
+
+	return ppqueue.TakeInflight(queueDir, key, INFLIGHT_GRACE)
+}
+
+// advanceJob moves the waiting job of the screenshot forward to the time
+// the coalesced submission is due, at once unless it is delayed itself.
+func advanceJob(puppeteerConf *ppconf.PuppeteerConf, screenshotInfo *pppool.ScreenshotInfo, jobControl map[string]string) {
+	notBefore, err := strconv.ParseInt(jobControl[ppqueue.NOT_BEFORE], 10, 64)
+	if nil != err {
+		notBefore = time.Now().Unix()
+	}
+
+	if !ppqueue.AdvanceWaitJobs(puppeteerConf.QueueDir, screenshotInfo.Fingerprint, notBefore) {
+		return
+	}
+
+	ppqueue.TouchInflight(puppeteerConf.QueueDir, screenshotInfo.Fingerprint, notBefore)
+	pppool.UpdateStateFile(pppool.GetScreenshotStatePath(screenshotInfo), map[string]string{pppool.NOT_BEFORE: strconv.FormatInt(notBefore, 10)})
+	screenshotInfo.NotBefore = notBefore
+}
+
+// addCallback passes the callback of a coalesced submission to the job in
+// flight. If the job completed meanwhile, its callbacks may have been taken
+// already, those left are told at once.
+func addCallback(puppeteerConf *ppconf.PuppeteerConf, targetURL string, key string, jobControl map[string]string) {
+	callbackURL := jobControl[ppqueue.CALLBACK_URL]
+	if "" == callbackURL || !ppqueue.AddCallback(puppeteerConf.QueueDir, key, callbackURL) {
+		return
+	}
+
+	if !ppqueue.IsInflight(puppeteerConf.QueueDir, key) {
+		Complete(puppeteerConf, map[string]string{ppqueue.KEY: key, ppqueue.URL: targetURL})
+	}
+}
+
+// getErrorMessage tells what went wrong with the job of a failed or
+// cancelled screenshot, or an empty string if nothing did.
+func getErrorMessage(screenshotInfo *pppool.ScreenshotInfo) string {
+	switch screenshotInfo.Status {
+	case pppool.STAT_NO_MATCH:
+		return "selector matched nothing"
+	case pppool.STAT_CANCELLED:
+		return "job cancelled"
+	case pppool.STAT_FAILED:
+		break
+	default:
+		return ""
+	}
+
+	switch screenshotInfo.Reason {
+	case pppool.REASON_TIMEOUT:
+		return fmt.Sprintf("render timed out after %d attempts", screenshotInfo.Attempts)
+	case pppool.REASON_ORPHANED:
+		return fmt.Sprintf("render orphaned after %d attempts", screenshotInfo.Attempts)
+	}
+
+	return fmt.Sprintf("render exited with code %d after %d attempts", screenshotInfo.ExitCode, screenshotInfo.Attempts)
+}
+
+// Complete posts the final state of the job to its callback url and to
+// those of the submissions coalesced into it. It is called once the job is
+// done, failed for good or cancelled, and its in-flight mark is removed.
+func Complete(puppeteerConf *ppconf.PuppeteerConf, jobInfo map[string]string) {
+	callbackList := ppqueue.TakeCallbacks(puppeteerConf.QueueDir, jobInfo[ppqueue.KEY])
+	if callbackURL := jobInfo[ppqueue.CALLBACK_URL]; "" != callbackURL {
+		callbackList = append([]string{callbackURL}, callbackList...)
+	}
+	if 0 == len(callbackList) {
+		return
+	}
+
+	screenshotInfo := pppool.GetScreenshotInfoByFingerprint(puppeteerConf.PoolDir, jobInfo[ppqueue.KEY])
+	if nil == screenshotInfo {
+		return
+	}
+
+	payload, _ := json.Marshal(ppnotify.CompletionPayload{
+		Event:       ppnotify.EVENT_COMPLETE,
+		Key:         screenshotInfo.Fingerprint,
+		URL:         jobInfo[ppqueue.URL],
+		Status:      screenshotInfo.Status,
+		LastUpdate:  screenshotInfo.LastUpdate,
+		Reason:      screenshotInfo.Reason,
+		ExitCode:    screenshotInfo.ExitCode,
+		Attempts:    screenshotInfo.Attempts,
+		WaitOutcome: screenshotInfo.WaitOutcome,
+		NotBefore:   screenshotInfo.NotBefore,
+		Stale:       screenshotInfo.Stale,
+		Error:       getErrorMessage(screenshotInfo),
+		Time:        time.Now().Unix()})
+
+	// the same url is told once, however many submissions gave it.
+	doneMap := make(map[string]bool)
+	for _, callbackURL := range callbackList {
+		if doneMap[callbackURL] {
+			continue
+		}
+		doneMap[callbackURL] = true
+
+		if !ppnotify.Enqueue(puppeteerConf.QueueDir, callbackURL, puppeteerConf.WebhookSecret, ppnotify.EVENT_COMPLETE, payload) {
+			log.Printf("queue callback of %s to %s failed\n", screenshotInfo.Fingerprint, callbackURL)
+		}
+	}
+}
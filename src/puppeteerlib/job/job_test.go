@@ -0,0 +1,177 @@
+package job
+
+import (
+	"os"
+	ppconf "puppeteerlib/conf"
+	pppool "puppeteerlib/pool"
+	ppqueue "puppeteerlib/queue"
+	ppstrutil "puppeteerlib/strutil"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestConf(t *testing.T) *ppconf.PuppeteerConf {
+	baseDir := t.TempDir()
+	puppeteerConf := &ppconf.PuppeteerConf{PoolDir: baseDir + "/pool", QueueDir: baseDir + "/queue", PhantomJSBin: os.Args[0]}
+	if !ppconf.ChkPuppeteerConf(puppeteerConf) {
+		t.Fatal("queue setup failed")
+	}
+
+	return puppeteerConf
+}
+
+func countJobs(dirPath string, key string) int {
+	ret := 0
+	for _, jobName := range ppqueue.ListJobDir(dirPath) {
+		if key == ppqueue.ParseJobFileName(jobName).Key {
+			ret++
+		}
+	}
+
+	return ret
+}
+
+// finishJob makes the job of key done as a slave would, except that the
+// in-flight mark and the run job are left as given.
+func finishJob(t *testing.T, puppeteerConf *ppconf.PuppeteerConf, key string, keepRunJob bool) {
+	queueDir := puppeteerConf.QueueDir
+	for _, jobName := range ppqueue.ListJobDir(ppqueue.GetJobWaitDir(queueDir)) {
+		if key != ppqueue.ParseJobFileName(jobName).Key {
+			continue
+		}
+
+		waitFile := ppqueue.GetJobWaitDir(queueDir) + string(os.PathSeparator) + jobName
+		if keepRunJob {
+			os.Rename(waitFile, ppqueue.GetJobRunDir(queueDir)+string(os.PathSeparator)+jobName)
+		} else {
+			os.Remove(waitFile)
+		}
+	}
+
+	screenshotInfo := pppool.GetScreenshotInfoByFingerprint(puppeteerConf.PoolDir, key)
+	pppool.UpdateStateFile(pppool.GetScreenshotStatePath(screenshotInfo), map[string]string{pppool.STATE: pppool.STATE_FAILED, pppool.REASON: pppool.REASON_ERR})
+}
+
+func TestSubmitCoalesce(t *testing.T) {
+	puppeteerConf := newTestConf(t)
+	targetURL := "http://example.com/coalesce"
+
+	for idx, want := range []int{SUBMIT_QUEUED, SUBMIT_COALESCED, SUBMIT_COALESCED} {
+		screenshotInfo, result := Submit(puppeteerConf, targetURL, "ua", map[string]string{}, map[string]string{})
+		if want != result || pppool.STAT_QUEUED != screenshotInfo.Status {
+			t.Errorf("submission %d: result %d status %d, want %d queued", idx, result, screenshotInfo.Status, want)
+		}
+	}
+
+	key := ppstrutil.URLOptions2Fingerprint(targetURL, map[string]string{})
+	if jobCnt := countJobs(ppqueue.GetJobWaitDir(puppeteerConf.QueueDir), key); 1 != jobCnt {
+		t.Errorf("%d jobs queued, want 1", jobCnt)
+	}
+}
+
+func TestSubmitLeftMark(t *testing.T) {
+	caseList := []struct {
+		name       string
+		markAge    time.Duration
+		keepRunJob bool
+		removeMark bool
+		want       int
+	}{
+		// the mark of a submission which died before queueing its job.
+		{"old mark of a done job", time.Minute, false, false, SUBMIT_QUEUED},
+		// the mark of a submission which is queueing its job.
+		{"young mark of a done job", 0, false, false, SUBMIT_COALESCED},
+		// the slave is about to remove the mark of the job it completed.
+		{"mark of a completing job", time.Minute, true, true, SUBMIT_QUEUED},
+	}
+
+	for idx, c := range caseList {
+		puppeteerConf := newTestConf(t)
+		queueDir := puppeteerConf.QueueDir
+		targetURL := "http://example.com/left/" + string(rune('a'+idx))
+		key := ppstrutil.URLOptions2Fingerprint(targetURL, map[string]string{})
+
+		if _, result := Submit(puppeteerConf, targetURL, "ua", map[string]string{}, map[string]string{}); SUBMIT_QUEUED != result {
+			t.Fatalf("%s: first submission result %d", c.name, result)
+		}
+		finishJob(t, puppeteerConf, key, c.keepRunJob)
+		markTime := time.Now().Add(-c.markAge)
+		os.Chtimes(ppqueue.GetJobInflightDir(queueDir)+string(os.PathSeparator)+key, markTime, markTime)
+
+		if c.removeMark {
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				ppqueue.RemoveInflight(queueDir, key)
+			}()
+		}
+
+		screenshotInfo, result := Submit(puppeteerConf, targetURL, "ua", map[string]string{}, map[string]string{})
+		if c.want != result || pppool.STAT_QUEUED != screenshotInfo.Status {
+			t.Errorf("%s: result %d status %d, want %d queued", c.name, result, screenshotInfo.Status, c.want)
+		}
+
+		wantCnt := 0
+		if SUBMIT_QUEUED == c.want {
+			wantCnt = 1
+		}
+		if jobCnt := countJobs(ppqueue.GetJobWaitDir(queueDir), key); wantCnt != jobCnt {
+			t.Errorf("%s: %d jobs waiting, want %d", c.name, jobCnt, wantCnt)
+		}
+		if !ppqueue.IsInflight(queueDir, key) {
+			t.Errorf("%s: no in-flight mark", c.name)
+		}
+	}
+}
+
+func TestSubmitAdvance(t *testing.T) {
+	puppeteerConf := newTestConf(t)
+	queueDir := puppeteerConf.QueueDir
+	targetURL := "http://example.com/delayed"
+	key := ppstrutil.URLOptions2Fingerprint(targetURL, map[string]string{})
+	now := time.Now().Unix()
+	delayed := now + 3*86400
+
+	caseList := []struct {
+		name      string
+		notBefore string
+		want      int
+		wantDue   int64
+	}{
+		{"delayed job", strconv.FormatInt(delayed, 10), SUBMIT_QUEUED, delayed},
+		{"later submission", strconv.FormatInt(delayed+60, 10), SUBMIT_COALESCED, delayed},
+		{"earlier submission", strconv.FormatInt(delayed-60, 10), SUBMIT_COALESCED, delayed - 60},
+		{"immediate submission", "", SUBMIT_COALESCED, now},
+	}
+
+	for _, c := range caseList {
+		jobControl := map[string]string{}
+		if "" != c.notBefore {
+			jobControl[ppqueue.NOT_BEFORE] = c.notBefore
+		}
+
+		screenshotInfo, result := Submit(puppeteerConf, targetURL, "ua", map[string]string{}, jobControl)
+		if c.want != result {
+			t.Errorf("%s: result %d, want %d", c.name, result, c.want)
+		}
+
+		jobNameList := ppqueue.ListJobDir(ppqueue.GetJobWaitDir(queueDir))
+		if 1 != len(jobNameList) {
+			t.Fatalf("%s: %d jobs waiting, want 1", c.name, len(jobNameList))
+		}
+
+		// the submission takes less than a second or two.
+		if due := ppqueue.GetJobNotBefore(jobNameList[0]); c.wantDue > due || c.wantDue+2 < due {
+			t.Errorf("%s: job due at %d, want %d", c.name, due, c.wantDue)
+		}
+		if c.wantDue > screenshotInfo.NotBefore || c.wantDue+2 < screenshotInfo.NotBefore {
+			t.Errorf("%s: screenshot not before %d, want %d", c.name, screenshotInfo.NotBefore, c.wantDue)
+		}
+
+		// the mark of a delayed job does not expire before the job is due.
+		fileStat, err := os.Stat(ppqueue.GetJobInflightDir(queueDir) + string(os.PathSeparator) + key)
+		if nil != err || c.wantDue > fileStat.ModTime().Unix() || c.wantDue+2 < fileStat.ModTime().Unix() {
+			t.Errorf("%s: in-flight mark dated %v, want %d", c.name, fileStat.ModTime().Unix(), c.wantDue)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"math"
+	"os"
+	ppdiff "puppeteerlib/diff"
+	ppioutil "puppeteerlib/ioutil"
+	ppqueue "puppeteerlib/queue"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KEY           = "Key"
+	WEBHOOK       = "Webhook"
+	THRESHOLD     = "Threshold"
+	SCHEDULE_ID   = "ScheduleID"
+	ALERT_DIR     = "alert"
+	EVENT_CHANGE  = "change"
+	THRESHOLD_MAX = 1.0
+)
+
+var keyRegexp = regexp.MustCompile("^[a-f0-9]{32}\\.[\\d]+$")
+
+// Alert posts a change notification to Webhook whenever a new screenshot
+// of Key differs from the previous one in more than Threshold of its
+// pixels. ScheduleID is the schedule the alert was created with, if any.
+type Alert struct {
+	Key        string
+	Webhook    string
+	Secret     string
+	Threshold  float64
+	ScheduleID string
+	CreateTime int64
+}
+
+// ChangePayload is what a change notification tells.
+type ChangePayload struct {
+	Event        string
+	Key          string
+	URL          string
+	ScheduleID   string `json:",omitempty"`
+	From         int64
+	To           int64
+	ChangedRatio float64
+	Threshold    float64
+	RegionCnt    int
+	Regions      []ppdiff.Region
+	Time         int64
+}
+
+func GetAlertDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + ALERT_DIR
+	return ret
+}
+
+func getAlertPath(queueDir string, key string) string {
+	return GetAlertDir(queueDir) + string(os.PathSeparator) + key
+}
+
+// IsValidKey tells whether key looks like a screenshot key, so that it is
+// safe to be used as a file name.
+func IsValidKey(key string) bool {
+	return keyRegexp.MatchString(key)
+}
+
+// IsValidSecret tells whether secret can be kept in an ini file as it is,
+// that is it has no line breaks and is not quoted, the quotes would be
+// stripped when read.
+func IsValidSecret(secret string) bool {
+	quoted := 0 < len(secret) && '"' == secret[0] && '"' == secret[len(secret)-1]
+	return !quoted && !strings.ContainsAny(secret, "\r\n")
+}
+
+// NewAlert returns an alert of key, or nil if the threshold is not a number
+// from 0 to THRESHOLD_MAX or the secret is invalid.
+func NewAlert(key string, webhook string, secret string, threshold float64) *Alert {
+	if !IsValidKey(key) || !IsValidSecret(secret) || math.IsNaN(threshold) || math.IsInf(threshold, 0) || 0 > threshold || THRESHOLD_MAX < threshold {
+		return nil
+	}
+
+	return &Alert{Key: key,
+		Webhook:    webhook,
+		Secret:     secret,
+		Threshold:  threshold,
+		CreateTime: time.Now().Unix()}
+}
+
+// WriteAlert keeps the alert, replacing any earlier alert of its key.
+func WriteAlert(queueDir string, alert *Alert) bool {
+	if !IsValidKey(alert.Key) || !IsValidSecret(alert.Secret) {
+		return false
+	}
+
+	return ppioutil.WriteIni(getAlertPath(queueDir, alert.Key), map[string]string{
+		KEY:         alert.Key,
+		WEBHOOK:     alert.Webhook,
+		SECRET:      alert.Secret,
+		THRESHOLD:   strconv.FormatFloat(alert.Threshold, 'f', -1, 64),
+		SCHEDULE_ID: alert.ScheduleID,
+		CREATE_TIME: strconv.FormatInt(alert.CreateTime, 10)})
+}
+
+// ReadAlert returns the alert of key, or nil if there is none.
+func ReadAlert(queueDir string, key string) *Alert {
+	if !IsValidKey(key) {
+		return nil
+	}
+
+	alertInfo, err := ppioutil.ParseIni(getAlertPath(queueDir, key))
+	if nil != err || nil == alertInfo || key != alertInfo[KEY] || "" == alertInfo[WEBHOOK] {
+		return nil
+	}
+
+	ret := &Alert{Key: key,
+		Webhook:    alertInfo[WEBHOOK],
+		Secret:     alertInfo[SECRET],
+		ScheduleID: alertInfo[SCHEDULE_ID]}
+	ret.Threshold, _ = strconv.ParseFloat(alertInfo[THRESHOLD], 64)
+	ret.CreateTime, _ = strconv.ParseInt(alertInfo[CREATE_TIME], 10, 64)
+
+	return ret
+}
+
+// ListAlerts returns all the alerts kept in the queue dir.
+func ListAlerts(queueDir string) []*Alert {
+	ret := make([]*Alert, 0)
+
+	for _, fileName := range ppqueue.ListJobDir(GetAlertDir(queueDir)) {
+		if alert := ReadAlert(queueDir, fileName); nil != alert {
+			ret = append(ret, alert)
+		}
+	}
+
+	return ret
+}
+
+// RemoveAlert removes the alert of key, and returns false if there is no
+// such alert.
+func RemoveAlert(queueDir string, key string) bool {
+	if !IsValidKey(key) {
+		return false
+	}
+
+	return nil == os.Remove(getAlertPath(queueDir, key))
+}
+
+// RemoveScheduleAlerts removes the alerts created with the schedule of
+// scheduleID.
+func RemoveScheduleAlerts(queueDir string, scheduleID string) {
+	for _, alert := range ListAlerts(queueDir) {
+		if scheduleID == alert.ScheduleID {
+			RemoveAlert(queueDir, alert.Key)
+		}
+	}
+}
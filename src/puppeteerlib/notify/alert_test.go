@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+const testKey = "0123456789abcdef0123456789abcdef.1"
+
+func TestNewAlert(t *testing.T) {
+	for _, threshold := range []float64{0, 0.5, THRESHOLD_MAX} {
+		if nil == NewAlert(testKey, "http://a.com/", "", threshold) {
+			t.Errorf("threshold %v rejected", threshold)
+		}
+	}
+
+	for _, threshold := range []float64{-0.1, THRESHOLD_MAX + 0.1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if nil != NewAlert(testKey, "http://a.com/", "", threshold) {
+			t.Errorf("threshold %v accepted", threshold)
+		}
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	queueDir := t.TempDir()
+	os.MkdirAll(GetAlertDir(queueDir), 0755)
+
+	// a secret survives the ini file once it is valid.
+	for _, secret := range []string{"", "s3cret", "a=b", "==", `"x`, `x"`, `a"b"c`, " x "} {
+		if !IsValidSecret(secret) {
+			t.Errorf("secret %q rejected", secret)
+			continue
+		}
+
+		if !WriteAlert(queueDir, NewAlert(testKey, "http://a.com/", secret, 0)) {
+			t.Fatalf("secret %q not written", secret)
+		}
+		if alert := ReadAlert(queueDir, testKey); nil == alert || secret != alert.Secret {
+			t.Errorf("secret %q read back as %v", secret, alert)
+		}
+	}
+
+	for _, secret := range []string{`"`, `""`, `"x"`, "a\nb", "a\rb"} {
+		if IsValidSecret(secret) {
+			t.Errorf("secret %q accepted", secret)
+		}
+	}
+}
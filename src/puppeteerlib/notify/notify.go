@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	ppioutil "puppeteerlib/ioutil"
+	ppqueue "puppeteerlib/queue"
+	"strconv"
+	"time"
+)
+
+const (
+	ID               = "ID"
+	TARGET_URL       = "TargetURL"
+	SECRET           = "Secret"
+	EVENT            = "Event"
+	PAYLOAD          = "Payload"
+	ATTEMPTS         = "Attempts"
+	NEXT_TRY         = "NextTry"
+	CREATE_TIME      = "CreateTime"
+	NOTIFY_DIR       = "notify"
+	ID_LEN           = 32
+	HEADER_EVENT     = "X-Puppeteer-Event"
+	HEADER_DELIVERY  = "X-Puppeteer-Delivery"
+	HEADER_TIMESTAMP = "X-Puppeteer-Timestamp"
+	HEADER_SIGNATURE = "X-Puppeteer-Signature"
+	SIGNATURE_PREFIX = "sha256="
+	EVENT_COMPLETE   = "complete"
+)
+
+// Notification is a JSON Payload waiting to be posted to TargetURL. It is
+// kept on disk until delivered, NextTry tells when the next attempt is due.
+type Notification struct {
+	ID         string
+	TargetURL  string
+	Secret     string
+	Event      string
+	Payload    string
+	Attempts   int64
+	NextTry    int64
+	CreateTime int64
+}
+
+// CompletionPayload tells the final state of a job, as GET /info/ does,
+// and what went wrong if it failed.
+type CompletionPayload struct {
+	Event       string
+	Key         string
+	URL         string
+	Status      uint8
+	LastUpdate  int64
+	Reason      string
+	ExitCode    int
+	Attempts    int
+	WaitOutcome string
+	NotBefore   int64
+	Stale       bool
+	Error       string `json:",omitempty"`
+	Time        int64
+}
+
+func GetNotifyDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + NOTIFY_DIR
+	return ret
+}
+
+func getNotificationPath(queueDir string, id string) string {
+	return GetNotifyDir(queueDir) + string(os.PathSeparator) + id
+}
+
+// IsValidID tells whether id looks like an id returned by NewID, so that
+// it is safe to be used as a file name.
+func IsValidID(id string) bool {
+	if ID_LEN != len(id) {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+
+	return nil == err
+}
+
+// NewID returns a random id of ID_LEN hex digits, or an empty string if
+// no random bytes are available.
+func NewID() string {
+	idBytes := make([]byte, ID_LEN/2)
+	if _, err := rand.Read(idBytes); nil != err {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", idBytes)
+}
+
+// Enqueue keeps payload to be posted to targetURL at once, signed with
+// secret, and returns false if it could not be written.
+func Enqueue(queueDir string, targetURL string, secret string, event string, payload []byte) bool {
+	id := NewID()
+	if "" == id {
+		return false
+	}
+
+	now := time.Now().Unix()
+
+	return WriteNotification(queueDir, &Notification{ID: id,
+		TargetURL:  targetURL,
+		Secret:     secret,
+		Event:      event,
+		Payload:    string(payload),
+		NextTry:    now,
+		CreateTime: now})
+}
+
+func WriteNotification(queueDir string, notification *Notification) bool {
+	return ppioutil.WriteIni(getNotificationPath(queueDir, notification.ID), map[string]string{
+		ID:          notification.ID,
+		TARGET_URL:  notification.TargetURL,
+		SECRET:      notification.Secret,
+		EVENT:       notification.Event,
+		PAYLOAD:     notification.Payload,
+		ATTEMPTS:    strconv.FormatInt(notification.Attempts, 10),
+		NEXT_TRY:    strconv.FormatInt(notification.NextTry, 10),
+		CREATE_TIME: strconv.FormatInt(notification.CreateTime, 10)})
+}
+
+// ReadNotification returns the notification of id, or nil if there is none.
+func ReadNotification(queueDir string, id string) *Notification {
+	if !IsValidID(id) {
+		return nil
+	}
+
+	notificationInfo, err := ppioutil.ParseIni(getNotificationPath(queueDir, id))
+	if nil != err || nil == notificationInfo || id != notificationInfo[ID] {
+		return nil
+	}
+
+	ret := &Notification{ID: id,
+		TargetURL: notificationInfo[TARGET_URL],
+		Secret:    notificationInfo[SECRET],
+		Event:     notificationInfo[EVENT],
+		Payload:   notificationInfo[PAYLOAD]}
+	ret.Attempts, _ = strconv.ParseInt(notificationInfo[ATTEMPTS], 10, 64)
+	ret.NextTry, _ = strconv.ParseInt(notificationInfo[NEXT_TRY], 10, 64)
+	ret.CreateTime, _ = strconv.ParseInt(notificationInfo[CREATE_TIME], 10, 64)
+
+	return ret
+}
+
+// ListNotifications returns the notifications waiting for delivery.
+func ListNotifications(queueDir string) []*Notification {
+	ret := make([]*Notification, 0)
+
+	for _, fileName := range ppqueue.ListJobDir(GetNotifyDir(queueDir)) {
+		if !IsValidID(fileName) {
+			continue
+		}
+
+		if notification := ReadNotification(queueDir, fileName); nil != notification {
+			ret = append(ret, notification)
+		}
+	}
+
+	return ret
+}
+
+func RemoveNotification(queueDir string, id string) {
+	if IsValidID(id) {
+		os.Remove(getNotificationPath(queueDir, id))
+	}
+}
+
+// Sign returns the signature of a payload posted at timestamp, the hex
+// HMAC-SHA256 of "{timestamp}.{payload}" keyed with secret.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+
+	return SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver posts the notification, signed if it has a secret, and returns
+// an error unless the target answers with a 2xx status.
+func Deliver(notification *Notification, timeout time.Duration) error {
+	payload := []byte(notification.Payload)
+	req, err := http.NewRequest("POST", notification.TargetURL, bytes.NewReader(payload))
+	if nil != err {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_EVENT, notification.Event)
+	req.Header.Set(HEADER_DELIVERY, notification.ID)
+	req.Header.Set(HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	if "" != notification.Secret {
+		req.Header.Set(HEADER_SIGNATURE, Sign(notification.Secret, timestamp, payload))
+	}
+
+	client := http.Client{Timeout: timeout}
+	rsp, err := client.Do(req)
+	if nil != err {
+		return err
+	}
+	rsp.Body.Close()
+
+	if http.StatusOK > rsp.StatusCode || http.StatusMultipleChoices <= rsp.StatusCode {
+		return fmt.Errorf("status %d", rsp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package pool
+
+import (
+	"os"
+	"syscall"
+)
+
+// getAccessTime returns the last access time of the file as unix time.
+func getAccessTime(fileInfo os.FileInfo) int64 {
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return stat.Atim.Sec
+	}
+
+	return fileInfo.ModTime().Unix()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package pool
+
+import (
+	"os"
+)
+
+// getAccessTime returns the modification time of the file, since access
+// times are not read on this platform.
+func getAccessTime(fileInfo os.FileInfo) int64 {
+	return fileInfo.ModTime().Unix()
+}
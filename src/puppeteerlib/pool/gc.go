@@ -0,0 +1,208 @@
+package pool
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// eviction frees this share of PoolMaxBytes below the limit, so that it
+	// does not run again after the next few renders.
+	EVICT_LOW_WATERMARK = 0.9
+)
+
+// GCStat tells what a garbage collection removed.
+type GCStat struct {
+	FileCnt int64
+	ByteCnt int64
+	DirCnt  int64
+}
+
+// suffixes of the files kept in the pool for each key.
+var gcSuffixList = []string{SCREENSHOT_PREFIX, JPEG_PREFIX, PDF_PREFIX, LOG_PREFIX, STATE_PREFIX}
+
+// getPoolFileKey returns the key of a file kept in the pool, or an empty
+// string if fileName is not such a file. Versions and renders of a
+// screenshot belong to its key.
+func getPoolFileKey(fileName string) string {
+	for _, suffix := range gcSuffixList {
+		if strings.HasSuffix(fileName, suffix) {
+			return trimVersionInfix(strings.TrimSuffix(fileName, suffix))
+		}
+	}
+
+	return ""
+}
+
+func listDir(dirPath string) []os.FileInfo {
+	dirHandle, err := os.Open(dirPath)
+	if nil != err {
+		return nil
+	}
+	defer dirHandle.Close()
+
+	fileInfoList, _ := dirHandle.Readdir(-1)
+
+	return fileInfoList
+}
+
+// CollectGarbage removes the screenshots, logs and states of poolDir which
+// were not modified for retention seconds, except those of the keys for
+// which keep returns true, and removes the shard directories left empty.
+func CollectGarbage(poolDir string, retention int64, keep func(string) bool) GCStat {
+	ret := GCStat{}
+	deadline := time.Now().Unix() - retention
+
+	for _, shardInfo := range listDir(poolDir) {
+		if !shardInfo.IsDir() {
+			continue
+		}
+		shardDir := poolDir + string(os.PathSeparator) + shardInfo.Name()
+
+		for _, subShardInfo := range listDir(shardDir) {
+			if !subShardInfo.IsDir() {
+				continue
+			}
+			subShardDir := shardDir + string(os.PathSeparator) + subShardInfo.Name()
+
+			for _, fileInfo := range listDir(subShardDir) {
+				key := getPoolFileKey(fileInfo.Name())
+				if fileInfo.IsDir() || "" == key || deadline < fileInfo.ModTime().Unix() || keep(key) {
+					continue
+				}
+
+				if err := os.Remove(subShardDir + string(os.PathSeparator) + fileInfo.Name()); nil == err {
+					ret.FileCnt++
+					ret.ByteCnt += fileInfo.Size()
+				}
+			}
+
+			// fails unless empty.
+			if err := os.Remove(subShardDir); nil == err {
+				ret.DirCnt++
+			}
+		}
+
+		if err := os.Remove(shardDir); nil == err {
+			ret.DirCnt++
+		}
+	}
+
+	return ret
+}
+
+// poolEntry is what the pool keeps for a key, with the last time any of
+// its files was read or written.
+type poolEntry struct {
+	dirPath    string
+	key        string
+	fileList   []os.FileInfo
+	lastAccess int64
+}
+
+type poolEntryList []*poolEntry
+
+func (this poolEntryList) Len() int {
+	return len(this)
+}
+
+func (this poolEntryList) Less(i, j int) bool {
+	return this[i].lastAccess < this[j].lastAccess
+}
+
+func (this poolEntryList) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+// scanPool returns the entries of poolDir and their total size.
+func scanPool(poolDir string) (poolEntryList, int64) {
+	ret := make(poolEntryList, 0)
+	totalSize := int64(0)
+
+	for _, shardInfo := range listDir(poolDir) {
+		if !shardInfo.IsDir() {
+			continue
+		}
+		shardDir := poolDir + string(os.PathSeparator) + shardInfo.Name()
+
+		for _, subShardInfo := range listDir(shardDir) {
+			if !subShardInfo.IsDir() {
+				continue
+			}
+			subShardDir := shardDir + string(os.PathSeparator) + subShardInfo.Name()
+
+			entryMap := make(map[string]*poolEntry)
+			for _, fileInfo := range listDir(subShardDir) {
+				key := getPoolFileKey(fileInfo.Name())
+				if fileInfo.IsDir() || "" == key {
+					continue
+				}
+
+				entry, ok := entryMap[key]
+				if !ok {
+					entry = &poolEntry{dirPath: subShardDir, key: key}
+					entryMap[key] = entry
+					ret = append(ret, entry)
+				}
+				entry.fileList = append(entry.fileList, fileInfo)
+				totalSize += fileInfo.Size()
+
+				lastAccess := getAccessTime(fileInfo)
+				if modTime := fileInfo.ModTime().Unix(); modTime > lastAccess {
+					lastAccess = modTime
+				}
+				if lastAccess > entry.lastAccess {
+					entry.lastAccess = lastAccess
+				}
+			}
+		}
+	}
+
+	return ret, totalSize
+}
+
+// EvictPool removes the least recently used keys from poolDir, except the
+// keys for which keep returns true, once the files of poolDir take more
+// than maxBytes. Keys are removed until EVICT_LOW_WATERMARK of maxBytes is
+// reached, and the shard directories left empty are removed.
+func EvictPool(poolDir string, maxBytes int64, keep func(string) bool) GCStat {
+	ret := GCStat{}
+
+	entryList, totalSize := scanPool(poolDir)
+	if totalSize <= maxBytes {
+		return ret
+	}
+	sort.Sort(entryList)
+
+	targetSize := int64(float64(maxBytes) * EVICT_LOW_WATERMARK)
+	for _, entry := range entryList {
+		if totalSize <= targetSize {
+			break
+		}
+
+		if keep(entry.key) {
+			continue
+		}
+
+		for _, fileInfo := range entry.fileList {
+			if err := os.Remove(entry.dirPath + string(os.PathSeparator) + fileInfo.Name()); nil == err {
+				ret.FileCnt++
+				ret.ByteCnt += fileInfo.Size()
+				totalSize -= fileInfo.Size()
+			}
+		}
+
+		// fails unless empty.
+		if err := os.Remove(entry.dirPath); nil == err {
+			ret.DirCnt++
+			if err := os.Remove(filepath.Dir(entry.dirPath)); nil == err {
+				ret.DirCnt++
+			}
+		}
+	}
+
+	return ret
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	ppioutil "puppeteerlib/ioutil"
 	ppstrutil "puppeteerlib/strutil"
+	"time"
 )
 
 const (
@@ -12,15 +13,67 @@ const (
 	STAT_READY
 	STAT_RUNNING
 	STAT_NOT_EXISTS
+	STAT_NO_MATCH
+	STAT_QUEUED
+	STAT_FAILED
+	STAT_EXPIRED
+	STAT_CANCELLED
 	SCREENSHOT_PREFIX = ".png"
+	JPEG_PREFIX       = ".jpg"
+	PDF_PREFIX        = ".pdf"
 	LOG_PREFIX        = ".log"
+	FORMAT_PNG        = "png"
+	FORMAT_JPEG       = "jpeg"
+	FORMAT_PDF        = "pdf"
 )
 
+var FORMAT_LIST = []string{FORMAT_PNG, FORMAT_JPEG, FORMAT_PDF}
+
+var formatPrefixMap = map[string]string{
+	FORMAT_PNG:  SCREENSHOT_PREFIX,
+	FORMAT_JPEG: JPEG_PREFIX,
+	FORMAT_PDF:  PDF_PREFIX}
+
+var formatContentTypeMap = map[string]string{
+	FORMAT_PNG:  "image/png",
+	FORMAT_JPEG: "image/jpeg",
+	FORMAT_PDF:  "application/pdf"}
+
 type ScreenshotInfo struct {
 	PoolDir     string
 	Fingerprint string
+	Format      string
 	Status      uint8
 	LastUpdate  int64
+	Reason      string
+	ExitCode    int
+	Attempts    int
+	WaitOutcome string
+	NotBefore   int64
+	Stale       bool
+}
+
+func IsValidFormat(format string) bool {
+	_, ret := formatPrefixMap[format]
+	return ret
+}
+
+// GetFormatPrefix returns the file name suffix of the given format,
+// png is assumed for empty or unknown format.
+func GetFormatPrefix(format string) string {
+	if ret, ok := formatPrefixMap[format]; ok {
+		return ret
+	}
+
+	return SCREENSHOT_PREFIX
+}
+
+func GetFormatContentType(format string) string {
+	if ret, ok := formatContentTypeMap[format]; ok {
+		return ret
+	}
+
+	return formatContentTypeMap[FORMAT_PNG]
 }
 
 func GetScreenshotInfo(poolDir string, url string) *ScreenshotInfo {
@@ -49,11 +102,21 @@ func GetScreenshotInfoByFingerprint(poolDir string, fingerprint string) *Screens
 	return ret
 }
 
+// setupScreenshotInfo detects the format by probing the pool for a file of
+// each known format, since the key alone does not tell the format. The
+// status is guessed from the pool files, unless a job state is persisted.
 func setupScreenshotInfo(info *ScreenshotInfo) {
-	filePath := GetScreenshotFilePath(info)
+	var fileInfo os.FileInfo
+	var err error
 	logPath := GetScreenshotLogPath(info)
 
-	fileInfo, err := os.Stat(filePath)
+	for _, format := range FORMAT_LIST {
+		info.Format = format
+		if fileInfo, err = os.Stat(GetScreenshotFilePath(info)); nil == err {
+			break
+		}
+	}
+
 	if nil == err {
 		info.Status = STAT_READY
 		info.LastUpdate = fileInfo.ModTime().Unix()
@@ -65,6 +128,11 @@ func setupScreenshotInfo(info *ScreenshotInfo) {
 		} else {
 			info.Status = STAT_NOT_EXISTS
 		}
+		info.Format = FORMAT_PNG
+	}
+
+	if stateInfo := ReadStateFile(GetScreenshotStatePath(info)); nil != stateInfo {
+		applyScreenshotState(info, stateInfo)
 	}
 }
 
@@ -73,7 +141,7 @@ func GetScreenshotFilePath(info *ScreenshotInfo) string {
 		return ""
 	}
 
-	ret := info.PoolDir + string(os.PathSeparator) + info.Fingerprint + SCREENSHOT_PREFIX
+	ret := info.PoolDir + string(os.PathSeparator) + info.Fingerprint + GetFormatPrefix(info.Format)
 
 	return ret
 }
@@ -108,3 +176,13 @@ func AppendScreenshotLog(info *ScreenshotInfo, logToAppend string) bool {
 
 	return ret
 }
+
+// TouchScreenshot records a read of the screenshot in its access time,
+// which tells the least recently used screenshots to evict. The
+// modification time, which tells the age of the screenshot, is kept.
+func TouchScreenshot(info *ScreenshotInfo) {
+	filePath := GetScreenshotFilePath(info)
+	if fileInfo, err := os.Stat(filePath); nil == err {
+		os.Chtimes(filePath, time.Now(), fileInfo.ModTime())
+	}
+}
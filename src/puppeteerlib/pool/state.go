@@ -0,0 +1,127 @@
+package pool
+
+import (
+	"os"
+	ppioutil "puppeteerlib/ioutil"
+	"strconv"
+	"time"
+)
+
+const (
+	STATE_PREFIX    = ".state"
+	STATE           = "State"
+	REASON          = "Reason"
+	EXIT_CODE       = "ExitCode"
+	ATTEMPTS        = "Attempts"
+	UPDATE_TIME     = "UpdateTime"
+	WAIT_OUTCOME    = "WaitOutcome"
+	NOT_BEFORE      = "NotBefore"
+	STATE_QUEUED    = "queued"
+	STATE_RUNNING   = "running"
+	STATE_READY     = "ready"
+	STATE_FAILED    = "failed"
+	STATE_EXPIRED   = "expired"
+	STATE_CANCELLED = "cancelled"
+	REASON_ERR      = "error"
+	REASON_NO_MATCH = "nomatch"
+	REASON_TIMEOUT  = "timeout"
+	REASON_ORPHANED = "orphaned"
+)
+
+var stateStatusMap = map[string]uint8{
+	STATE_QUEUED:    STAT_QUEUED,
+	STATE_RUNNING:   STAT_RUNNING,
+	STATE_READY:     STAT_READY,
+	STATE_FAILED:    STAT_FAILED,
+	STATE_EXPIRED:   STAT_EXPIRED,
+	STATE_CANCELLED: STAT_CANCELLED}
+
+func GetScreenshotStatePath(info *ScreenshotInfo) string {
+	if "" == info.PoolDir {
+		return ""
+	}
+
+	ret := info.PoolDir + string(os.PathSeparator) + info.Fingerprint + STATE_PREFIX
+
+	return ret
+}
+
+// ReadStateFile returns the job state persisted at statePath, or nil if
+// there is none.
+func ReadStateFile(statePath string) map[string]string {
+	if "" == statePath {
+		return nil
+	}
+
+	stateInfo, err := ppioutil.ParseIni(statePath)
+	if nil != err {
+		return nil
+	}
+
+	return stateInfo
+}
+
+// UpdateStateFile merges stateUpdate into the job state persisted at
+// statePath and stamps it with the current time. Empty values remove the
+// property from the state.
+func UpdateStateFile(statePath string, stateUpdate map[string]string) bool {
+	if "" == statePath {
+		return false
+	}
+
+	stateInfo := ReadStateFile(statePath)
+	if nil == stateInfo {
+		stateInfo = make(map[string]string)
+	}
+
+	for name, val := range stateUpdate {
+		if "" == val {
+			delete(stateInfo, name)
+		} else {
+			stateInfo[name] = val
+		}
+	}
+	stateInfo[UPDATE_TIME] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	return ppioutil.WriteIni(statePath, stateInfo)
+}
+
+// applyScreenshotState overrides the status guessed from the pool files
+// with the persisted job state. A ready state whose file is gone is left
+// to the guess.
+func applyScreenshotState(info *ScreenshotInfo, stateInfo map[string]string) {
+	if status, ok := stateStatusMap[stateInfo[STATE]]; ok && (STAT_READY != status || 0 < info.LastUpdate) {
+		info.Status = status
+	}
+
+	if STAT_FAILED == info.Status && REASON_NO_MATCH == stateInfo[REASON] {
+		info.Status = STAT_NO_MATCH
+	}
+
+	info.Reason = stateInfo[REASON]
+	info.WaitOutcome = stateInfo[WAIT_OUTCOME]
+	if exitCode, err := strconv.Atoi(stateInfo[EXIT_CODE]); nil == err {
+		info.ExitCode = exitCode
+	}
+	if attempts, err := strconv.Atoi(stateInfo[ATTEMPTS]); nil == err {
+		info.Attempts = attempts
+	}
+	if notBefore, err := strconv.ParseInt(stateInfo[NOT_BEFORE], 10, 64); nil == err && STAT_QUEUED == info.Status {
+		info.NotBefore = notBefore
+	}
+}
+
+// ApplyExpire marks a screenshot older than expire seconds as stale, and
+// reports it as expired if it is ready.
+func ApplyExpire(info *ScreenshotInfo, expire int64) {
+	info.Stale = 0 < info.LastUpdate && expire < (time.Now().Unix()-info.LastUpdate)
+	if STAT_READY == info.Status && info.Stale {
+		info.Status = STAT_EXPIRED
+	}
+}
+
+// IsFresh tells whether the screenshot is ready and not older than maxAge
+// seconds.
+func IsFresh(info *ScreenshotInfo, maxAge int64) bool {
+	return STAT_READY == info.Status && 0 < info.LastUpdate && maxAge >= (time.Now().Unix()-info.LastUpdate)
+}
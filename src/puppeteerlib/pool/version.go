@@ -0,0 +1,136 @@
+package pool
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	VERSION_INFIX = ".v"
+	RENDER_INFIX  = ".render"
+)
+
+// ScreenshotVersion is a screenshot of a key as rendered at Version, the
+// unix time of the render.
+type ScreenshotVersion struct {
+	Version int64
+	Size    int64
+	Current bool
+}
+
+// GetRenderPath returns where a job renders the screenshot of targetPath,
+// which is replaced by the render once it succeeds.
+func GetRenderPath(targetPath string) string {
+	ext := filepath.Ext(targetPath)
+	return strings.TrimSuffix(targetPath, ext) + RENDER_INFIX + ext
+}
+
+// GetVersionPath returns where the screenshot of targetPath rendered at
+// version is kept once it is replaced.
+func GetVersionPath(targetPath string, version int64) string {
+	ext := filepath.Ext(targetPath)
+	return strings.TrimSuffix(targetPath, ext) + VERSION_INFIX + strconv.FormatInt(version, 10) + ext
+}
+
+// CommitRender replaces the screenshot of targetPath with its render. The
+// replaced screenshot is kept as a version, as long as there are less than
+// keepVersions screenshots of the key.
+func CommitRender(targetPath string, keepVersions int64) bool {
+	if fileInfo, err := os.Stat(targetPath); nil == err && 1 < keepVersions {
+		os.Link(targetPath, GetVersionPath(targetPath, fileInfo.ModTime().Unix()))
+	}
+
+	if err := os.Rename(GetRenderPath(targetPath), targetPath); nil != err {
+		return false
+	}
+
+	versionList := listVersions(targetPath)
+	if 1 > keepVersions {
+		keepVersions = 1
+	}
+	for idx := keepVersions - 1; idx < int64(len(versionList)); idx++ {
+		os.Remove(GetVersionPath(targetPath, versionList[idx].Version))
+	}
+
+	return true
+}
+
+// listVersions returns the versions kept for the screenshot of targetPath,
+// the current one excluded, newest first.
+func listVersions(targetPath string) []ScreenshotVersion {
+	ret := make([]ScreenshotVersion, 0)
+	ext := filepath.Ext(targetPath)
+	versionPrefix := filepath.Base(strings.TrimSuffix(targetPath, ext)) + VERSION_INFIX
+
+	for _, fileInfo := range listDir(filepath.Dir(targetPath)) {
+		fileName := fileInfo.Name()
+		if !strings.HasPrefix(fileName, versionPrefix) || !strings.HasSuffix(fileName, ext) {
+			continue
+		}
+
+		version, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(fileName, versionPrefix), ext), 10, 64)
+		if nil == err {
+			ret = append(ret, ScreenshotVersion{Version: version, Size: fileInfo.Size()})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version > ret[j].Version
+	})
+
+	return ret
+}
+
+// ListScreenshotVersions returns the versions of the screenshot, the
+// current one included, newest first.
+func ListScreenshotVersions(info *ScreenshotInfo) []ScreenshotVersion {
+	ret := make([]ScreenshotVersion, 0)
+	filePath := GetScreenshotFilePath(info)
+
+	if fileInfo, err := os.Stat(filePath); nil == err {
+		ret = append(ret, ScreenshotVersion{Version: fileInfo.ModTime().Unix(), Size: fileInfo.Size(), Current: true})
+	}
+
+	for _, screenshotVersion := range listVersions(filePath) {
+		if 0 == len(ret) || ret[0].Version != screenshotVersion.Version {
+			ret = append(ret, screenshotVersion)
+		}
+	}
+
+	return ret
+}
+
+// GetScreenshotVersionPath returns the path of the screenshot rendered at
+// version, which may be the current one, or an empty string if there is
+// no such version.
+func GetScreenshotVersionPath(info *ScreenshotInfo, version int64) string {
+	for _, screenshotVersion := range ListScreenshotVersions(info) {
+		if version == screenshotVersion.Version {
+			if screenshotVersion.Current {
+				return GetScreenshotFilePath(info)
+			}
+			return GetVersionPath(GetScreenshotFilePath(info), version)
+		}
+	}
+
+	return ""
+}
+
+// trimVersionInfix returns the key of a pool file name stripped of its
+// suffix, without the version or render infix.
+func trimVersionInfix(name string) string {
+	if strings.HasSuffix(name, RENDER_INFIX) {
+		return strings.TrimSuffix(name, RENDER_INFIX)
+	}
+
+	if infixIdx := strings.LastIndex(name, VERSION_INFIX); -1 != infixIdx {
+		if _, err := strconv.ParseInt(name[infixIdx+len(VERSION_INFIX):], 10, 64); nil == err {
+			return name[:infixIdx]
+		}
+	}
+
+	return name
+}
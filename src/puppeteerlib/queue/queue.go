@@ -6,20 +6,57 @@ import (
 	"os"
 	ppioutil "puppeteerlib/ioutil"
 	"puppeteerlib/strutil"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	URL            = "URL"
+	KEY            = "Key"
 	TARGET_FILE    = "TargetFile"
 	LOG_FILE       = "LogFile"
+	STATE_FILE     = "StateFile"
 	USER_AGENT     = "UserAgent"
+	TIMEOUT        = "Timeout"
+	ATTEMPTS       = "Attempts"
+	NOT_BEFORE     = "NotBefore"
+	FAIL_REASON    = "FailReason"
+	PRIORITY       = "Priority"
+	QUEUE          = "Queue"
+	MAX_AGE        = "MaxAge"
+	CALLBACK_URL   = "CallbackURL"
+	WIDTH          = "Width"
+	HEIGHT         = "Height"
+	FULL_PAGE      = "FullPage"
+	FORMAT         = "Format"
+	QUALITY        = "Quality"
+	PAPER_SIZE     = "PaperSize"
+	ORIENTATION    = "Orientation"
+	SELECTOR       = "Selector"
+	CLIP           = "Clip"
+	DELAY_MS       = "DelayMs"
+	WAIT_SELECTOR  = "WaitForSelector"
+	WAIT_NET_IDLE  = "WaitForNetworkIdle"
+	MAX_WAIT_MS    = "MaxWaitMs"
 	JOB_PREFIX_MAX = uint16(10)
 	WAIT_DIR       = "wait"
 	INIT_DIR       = "init"
 	RUN_DIR        = "run"
+	DEAD_DIR       = "dead"
+	INFLIGHT_DIR   = "inflight"
+	CANCEL_DIR     = "cancel"
+	CALLBACK_DIR   = "callback"
+	NAME_SEP       = "_"
+	DEFAULT_QUEUE  = "default"
+
+	INFLIGHT_MAX_AGE = int64(86400)
 )
 
+// job properties passed to the render script as Name=Value arguments.
+var RENDER_OPTION_LIST = []string{WIDTH, HEIGHT, FULL_PAGE, FORMAT, QUALITY, PAPER_SIZE, ORIENTATION, SELECTOR, CLIP,
+	DELAY_MS, WAIT_SELECTOR, WAIT_NET_IDLE, MAX_WAIT_MS}
+
 func GetJobInitDir(queueDir string) string {
 	ret := queueDir + string(os.PathSeparator) + INIT_DIR
 	return ret
@@ -35,12 +72,363 @@ func GetJobWaitDir(queueDir string) string {
 	return ret
 }
 
+func GetJobDeadDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + DEAD_DIR
+	return ret
+}
+
+func GetJobInflightDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + INFLIGHT_DIR
+	return ret
+}
+
+// AddInflight marks the job of key as queued or running, and returns
+// false if it is marked already. Marks older than INFLIGHT_MAX_AGE are
+// considered leaked and replaced.
+func AddInflight(queueDir string, key string) bool {
+	inflightPath := GetJobInflightDir(queueDir) + string(os.PathSeparator) + key
+
+	for idx := 0; idx < 2; idx++ {
+		fileHandle, err := os.OpenFile(inflightPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, ppioutil.FILE_MASK)
+		if nil == err {
+			fileHandle.Close()
+			return true
+		}
+
+		fileStat, statErr := os.Stat(inflightPath)
+		if !os.IsExist(err) || nil != statErr || INFLIGHT_MAX_AGE > (time.Now().Unix()-fileStat.ModTime().Unix()) {
+			break
+		}
+		os.Remove(inflightPath)
+	}
+
+	return false
+}
+
+// TakeInflight replaces the in-flight mark of key by a new one, and
+// returns false if the mark is younger than minAge seconds or another
+// submission took it first. The mark is moved aside first, so that only
+// one submission takes it.
+func TakeInflight(queueDir string, key string, minAge int64) bool {
+	inflightPath := GetJobInflightDir(queueDir) + string(os.PathSeparator) + key
+	takenPath := GetJobInitDir(queueDir) + string(os.PathSeparator) + "." + key + NAME_SEP + strutil.GetRandomString(JOB_PREFIX_MAX)
+
+	if err := os.Rename(inflightPath, takenPath); nil != err {
+		return AddInflight(queueDir, key)
+	}
+	defer os.Remove(takenPath)
+
+	// a mark renewed meanwhile is put back, unless a newer one exists.
+	if fileStat, err := os.Stat(takenPath); nil != err || minAge > (time.Now().Unix()-fileStat.ModTime().Unix()) {
+		os.Link(takenPath, inflightPath)
+		return false
+	}
+
+	return AddInflight(queueDir, key)
+}
+
+// TouchInflight dates the in-flight mark of key to the unix timestamp
+// since, the not-before time of a delayed job, so that the mark does not
+// expire before the job is due.
+func TouchInflight(queueDir string, key string, since int64) {
+	sinceTime := time.Unix(since, 0)
+	os.Chtimes(GetJobInflightDir(queueDir)+string(os.PathSeparator)+key, sinceTime, sinceTime)
+}
+
+func IsInflight(queueDir string, key string) bool {
+	if "" == key {
+		return false
+	}
+
+	_, err := os.Stat(GetJobInflightDir(queueDir) + string(os.PathSeparator) + key)
+
+	return nil == err
+}
+
+func RemoveInflight(queueDir string, key string) {
+	if "" != key {
+		os.Remove(GetJobInflightDir(queueDir) + string(os.PathSeparator) + key)
+	}
+}
+
+func GetJobCancelDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + CANCEL_DIR
+	return ret
+}
+
+// AddCancel asks the slave running the job of key to abort it.
+func AddCancel(queueDir string, key string) bool {
+	fileHandle, err := os.OpenFile(GetJobCancelDir(queueDir)+string(os.PathSeparator)+key, os.O_CREATE|os.O_WRONLY, ppioutil.FILE_MASK)
+	if nil != err {
+		return false
+	}
+	fileHandle.Close()
+
+	return true
+}
+
+func IsCancelled(queueDir string, key string) bool {
+	if "" == key {
+		return false
+	}
+
+	_, err := os.Stat(GetJobCancelDir(queueDir) + string(os.PathSeparator) + key)
+
+	return nil == err
+}
+
+func RemoveCancel(queueDir string, key string) {
+	if "" != key {
+		os.Remove(GetJobCancelDir(queueDir) + string(os.PathSeparator) + key)
+	}
+}
+
+func GetJobCallbackDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + CALLBACK_DIR
+	return ret
+}
+
+// AddCallback asks for callbackURL to be told when the job of key in
+// flight completes, on behalf of a submission coalesced into the job.
+func AddCallback(queueDir string, key string, callbackURL string) bool {
+	fileHandle, err := os.OpenFile(GetJobCallbackDir(queueDir)+string(os.PathSeparator)+key, os.O_CREATE|os.O_APPEND|os.O_WRONLY, ppioutil.FILE_MASK)
+	if nil != err {
+		return false
+	}
+	defer fileHandle.Close()
+
+	_, err = io.WriteString(fileHandle, callbackURL+"\n")
+
+	return nil == err
+}
+
+// TakeCallbacks returns the callback urls added for key, and removes them.
+func TakeCallbacks(queueDir string, key string) []string {
+	ret := make([]string, 0)
+	if "" == key {
+		return ret
+	}
+
+	// the file is renamed first, so that no callback is added meanwhile.
+	callbackPath := GetJobCallbackDir(queueDir) + string(os.PathSeparator) + key
+	takenPath := callbackPath + NAME_SEP + strutil.GetRandomString(JOB_PREFIX_MAX)
+	if err := os.Rename(callbackPath, takenPath); nil != err {
+		return ret
+	}
+	defer os.Remove(takenPath)
+
+	content, err := ioutil.ReadFile(takenPath)
+	if nil != err {
+		return ret
+	}
+
+	for _, callbackURL := range strings.Split(string(content), "\n") {
+		if "" != callbackURL {
+			ret = append(ret, callbackURL)
+		}
+	}
+
+	return ret
+}
+
+// RemoveWaitJobs removes the waiting jobs of key and returns the removed
+// jobs.
+func RemoveWaitJobs(queueDir string, key string) []map[string]string {
+	ret := make([]map[string]string, 0)
+	waitDir := GetJobWaitDir(queueDir)
+
+	for _, jobName := range ListJobDir(waitDir) {
+		if key == ParseJobFileName(jobName).Key {
+			jobFile := waitDir + string(os.PathSeparator) + jobName
+			jobInfo := ReadJob(jobFile)
+			if err := os.Remove(jobFile); nil == err && nil != jobInfo {
+				ret = append(ret, jobInfo)
+			}
+		}
+	}
+
+	return ret
+}
+
+// AdvanceWaitJobs makes the waiting jobs of key due at the unix timestamp
+// notBefore, if they are delayed beyond it, and returns false if none is.
+// Each job is moved aside before it is queued again, so that it is not
+// picked up meanwhile.
+func AdvanceWaitJobs(queueDir string, key string, notBefore int64) bool {
+	ret := false
+	waitDir := GetJobWaitDir(queueDir)
+
+	for _, jobName := range ListJobDir(waitDir) {
+		if jobFileInfo := ParseJobFileName(jobName); key != jobFileInfo.Key || notBefore >= jobFileInfo.NotBefore {
+			continue
+		}
+
+		jobFile := waitDir + string(os.PathSeparator) + jobName
+		takenFile := GetJobInitDir(queueDir) + string(os.PathSeparator) + "." + jobName
+		if err := os.Rename(jobFile, takenFile); nil != err {
+			continue
+		}
+
+		if jobInfo := ReadJob(takenFile); nil != jobInfo && WriteJobNotBefore(queueDir, jobInfo, notBefore) {
+			os.Remove(takenFile)
+			ret = true
+		} else {
+			os.Rename(takenFile, jobFile)
+		}
+	}
+
+	return ret
+}
+
+func HasRunJob(queueDir string, key string) bool {
+	return hasJob(GetJobRunDir(queueDir), key)
+}
+
+func HasWaitJob(queueDir string, key string) bool {
+	return hasJob(GetJobWaitDir(queueDir), key)
+}
+
+func hasJob(dirPath string, key string) bool {
+	for _, jobName := range ListJobDir(dirPath) {
+		if key == ParseJobFileName(jobName).Key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JobFileInfo is what the name of a job file tells about the job, so
+// that the master can pick jobs without reading them. Job files are named
+// <notBefore>_<priority>_<queue>_<key>_<random>, where notBefore is the
+// enqueue time unless the job was delayed.
+type JobFileInfo struct {
+	Name      string
+	NotBefore int64
+	Priority  int64
+	Queue     string
+	Key       string
+}
+
+// ParseJobFileName returns the job properties encoded in jobFileName.
+// Names of older jobs lack the key, the priority and queue, or all of
+// them.
+func ParseJobFileName(jobFileName string) *JobFileInfo {
+	ret := &JobFileInfo{Name: jobFileName, Queue: DEFAULT_QUEUE}
+	partList := strings.Split(jobFileName, NAME_SEP)
+
+	if 2 <= len(partList) {
+		ret.NotBefore, _ = strconv.ParseInt(partList[0], 10, 64)
+	}
+
+	if 4 <= len(partList) {
+		ret.Priority, _ = strconv.ParseInt(partList[1], 10, 64)
+		if "" != partList[2] {
+			ret.Queue = partList[2]
+		}
+	}
+
+	if 5 == len(partList) {
+		ret.Key = partList[3]
+	}
+
+	return ret
+}
+
+// JobFileInfoList sorts jobs by descending priority, and by ascending
+// not-before time within the same priority.
+type JobFileInfoList []*JobFileInfo
+
+func (this JobFileInfoList) Len() int {
+	return len(this)
+}
+
+func (this JobFileInfoList) Less(i, j int) bool {
+	if this[i].Priority != this[j].Priority {
+		return this[i].Priority > this[j].Priority
+	}
+
+	return this[i].NotBefore < this[j].NotBefore
+}
+
+func (this JobFileInfoList) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+func IsValidQueueName(queueName string) bool {
+	if "" == queueName {
+		return false
+	}
+
+	for _, ch := range queueName {
+		if !(('a' <= ch && 'z' >= ch) || ('A' <= ch && 'Z' >= ch) || ('0' <= ch && '9' >= ch)) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func WriteJob(queueDir string, jobInfo map[string]string) bool {
+	return writeJobFile(queueDir, GetJobWaitDir(queueDir), jobInfo)
+}
+
+// WriteJobNotBefore queues a job which must not run before the unix
+// timestamp notBefore.
+func WriteJobNotBefore(queueDir string, jobInfo map[string]string, notBefore int64) bool {
+	jobInfo[NOT_BEFORE] = strconv.FormatInt(notBefore, 10)
+
+	return writeJobFile(queueDir, GetJobWaitDir(queueDir), jobInfo)
+}
+
+// WriteDeadJob keeps a job which exhausted its retries in the dead
+// directory, to be inspected or replayed later.
+func WriteDeadJob(queueDir string, jobInfo map[string]string) bool {
+	return writeJobFile(queueDir, GetJobDeadDir(queueDir), jobInfo)
+}
+
+// GetJobNotBefore returns the not-before timestamp encoded in the name of
+// a job file, or 0 if the job may run at once.
+func GetJobNotBefore(jobFileName string) int64 {
+	return ParseJobFileName(jobFileName).NotBefore
+}
+
+// GetJobMaxAge returns the age in seconds up to which an existing
+// screenshot satisfies the job, or defaultMaxAge if the job does not tell.
+func GetJobMaxAge(jobInfo map[string]string, defaultMaxAge int64) int64 {
+	if maxAge, err := strconv.ParseInt(jobInfo[MAX_AGE], 10, 64); nil == err && 0 <= maxAge {
+		return maxAge
+	}
+
+	return defaultMaxAge
+}
+
+func IsJobEligible(jobFileName string) bool {
+	return GetJobNotBefore(jobFileName) <= time.Now().Unix()
+}
+
+func getJobNamePrefix(jobInfo map[string]string) string {
+	notBefore, err := strconv.ParseInt(jobInfo[NOT_BEFORE], 10, 64)
+	if nil != err {
+		notBefore = time.Now().Unix()
+	}
+
+	priority, _ := strconv.ParseInt(jobInfo[PRIORITY], 10, 64)
+
+	queueName := jobInfo[QUEUE]
+	if !IsValidQueueName(queueName) {
+		queueName = DEFAULT_QUEUE
+	}
+
+	return strconv.FormatInt(notBefore, 10) + NAME_SEP + strconv.FormatInt(priority, 10) + NAME_SEP + queueName + NAME_SEP +
+		strings.Replace(jobInfo[KEY], NAME_SEP, "", -1) + NAME_SEP
+}
+
+func writeJobFile(queueDir string, targetDir string, jobInfo map[string]string) bool {
 	ret := false
 	initDir := GetJobInitDir(queueDir)
-	waitDir := GetJobWaitDir(queueDir)
 
-	fileHandle, err := ioutil.TempFile(initDir, "."+strutil.GetRandomString(JOB_PREFIX_MAX))
+	fileHandle, err := ioutil.TempFile(initDir, "."+getJobNamePrefix(jobInfo)+strutil.GetRandomString(JOB_PREFIX_MAX))
 	if nil != err {
 		return false
 	}
@@ -50,7 +438,7 @@ func WriteJob(queueDir string, jobInfo map[string]string) bool {
 
 	sepIdx := strings.LastIndex(tempPath, string(os.PathSeparator))
 	sepIdx += len(string(os.PathSeparator)) + 1
-	jobPath = waitDir + string(os.PathSeparator) + tempPath[sepIdx:]
+	jobPath = targetDir + string(os.PathSeparator) + tempPath[sepIdx:]
 
 	hasError := false
 	for jobPropName, jobPropVal := range jobInfo {
@@ -76,6 +464,20 @@ func WriteJob(queueDir string, jobInfo map[string]string) bool {
 	return ret
 }
 
+// ListJobDir returns the names of the job files in dirPath.
+func ListJobDir(dirPath string) []string {
+	ret := make([]string, 0)
+
+	if dirHandle, err := os.Open(dirPath); nil == err {
+		if nameList, err := dirHandle.Readdirnames(-1); nil == err {
+			ret = nameList
+		}
+		dirHandle.Close()
+	}
+
+	return ret
+}
+
 func ReadJob(jobFile string) map[string]string {
 	jobInfo, err := ppioutil.ParseIni(jobFile)
 
@@ -85,3 +487,15 @@ func ReadJob(jobFile string) map[string]string {
 
 	return jobInfo
 }
+
+func GetRenderArgs(jobInfo map[string]string) []string {
+	ret := make([]string, 0, len(RENDER_OPTION_LIST))
+
+	for _, optName := range RENDER_OPTION_LIST {
+		if optVal, optOk := jobInfo[optName]; optOk && "" != optVal {
+			ret = append(ret, optName+"="+optVal)
+		}
+	}
+
+	return ret
+}
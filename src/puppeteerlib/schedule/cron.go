@@ -0,0 +1,173 @@
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CRON_FIELD_CNT = 5
+	CRON_MAX_YEARS = 5
+	CRON_ALL_HOURS = uint64(1<<24 - 1)
+)
+
+// CronSpec is a parsed cron expression of the usual five fields, minute
+// hour day-of-month month day-of-week, each a bit set of allowed values.
+type CronSpec struct {
+	Minute  uint64
+	Hour    uint64
+	Dom     uint64
+	Month   uint64
+	Dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+type cronFieldRange struct {
+	min int
+	max int
+}
+
+var cronFieldRangeList = []cronFieldRange{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// ParseCron parses expr, fields may be *, a value, a range a-b, a list
+// of those separated by commas, each optionally followed by /step. Sunday
+// is 0 or 7 in the day-of-week field.
+func ParseCron(expr string) (*CronSpec, bool) {
+	fieldList := strings.Fields(expr)
+	if CRON_FIELD_CNT != len(fieldList) {
+		return nil, false
+	}
+
+	bitsList := make([]uint64, CRON_FIELD_CNT)
+	for idx, field := range fieldList {
+		bits, ok := parseCronField(field, cronFieldRangeList[idx])
+		if !ok {
+			return nil, false
+		}
+		bitsList[idx] = bits
+	}
+
+	ret := &CronSpec{Minute: bitsList[0], Hour: bitsList[1], Dom: bitsList[2], Month: bitsList[3], Dow: bitsList[4]}
+	if 0 != ret.Dow&(1<<7) {
+		ret.Dow |= 1
+	}
+	ret.domStar = strings.HasPrefix(fieldList[2], "*")
+	ret.dowStar = strings.HasPrefix(fieldList[4], "*")
+
+	return ret, true
+}
+
+func parseCronField(field string, fieldRange cronFieldRange) (uint64, bool) {
+	ret := uint64(0)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		slashIdx := strings.Index(part, "/")
+		if -1 != slashIdx {
+			var err error
+			if step, err = strconv.Atoi(part[slashIdx+1:]); nil != err || 0 >= step {
+				return 0, false
+			}
+			part = part[:slashIdx]
+		}
+
+		bgn, end := fieldRange.min, fieldRange.max
+		if "*" != part {
+			var err error
+			dashIdx := strings.Index(part, "-")
+			if -1 == dashIdx {
+				if bgn, err = strconv.Atoi(part); nil != err {
+					return 0, false
+				}
+				// as usual, N/step stands for N-max/step.
+				if -1 == slashIdx {
+					end = bgn
+				}
+			} else {
+				if bgn, err = strconv.Atoi(part[:dashIdx]); nil != err {
+					return 0, false
+				}
+				if end, err = strconv.Atoi(part[dashIdx+1:]); nil != err {
+					return 0, false
+				}
+			}
+		}
+
+		if bgn < fieldRange.min || end > fieldRange.max || bgn > end {
+			return 0, false
+		}
+
+		for val := bgn; val <= end; val += step {
+			ret |= 1 << uint(val)
+		}
+	}
+
+	return ret, true
+}
+
+// matchDay tells whether the day of t is allowed. As usual, if both the
+// day of month and the day of week are restricted, either may match.
+func (this *CronSpec) matchDay(t time.Time) bool {
+	domMatch := 0 != this.Dom&(1<<uint(t.Day()))
+	dowMatch := 0 != this.Dow&(1<<uint(t.Weekday()))
+
+	if this.domStar || this.dowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// forward returns next, or t a minute on if next, the start of a later
+// month, day or hour, falls into the hour skipped when clocks go forward,
+// which time.Date resolves to a time before the skip.
+func forward(t time.Time, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+
+	return t.Add(time.Minute)
+}
+
+// Next returns the first time after the unix timestamp after which
+// matches the expression in local time, or 0 if none is found within
+// CRON_MAX_YEARS.
+func (this *CronSpec) Next(after int64) int64 {
+	t := time.Unix(after, 0).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(CRON_MAX_YEARS, 0, 0)
+
+	for t.Before(limit) {
+		if 0 == this.Month&(1<<uint(t.Month())) {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+
+		if !this.matchDay(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+
+		if 0 == this.Hour&(1<<uint(t.Hour())) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+
+		if 0 == this.Minute&(1<<uint(t.Minute())) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		// the hour repeated when clocks go back matched already the first
+		// time round, unless every hour matches.
+		if earlier := t.Add(-time.Hour); CRON_ALL_HOURS != this.Hour && earlier.Day() == t.Day() && earlier.Hour() == t.Hour() {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t.Unix()
+	}
+
+	return 0
+}
@@ -0,0 +1,125 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func bits(valList ...int) uint64 {
+	ret := uint64(0)
+	for _, val := range valList {
+		ret |= 1 << uint(val)
+	}
+
+	return ret
+}
+
+func TestParseCron(t *testing.T) {
+	caseList := []struct {
+		expr   string
+		ok     bool
+		minute uint64
+		dow    uint64
+	}{
+		{"*/15 * * * *", true, bits(0, 15, 30, 45), bits(0, 1, 2, 3, 4, 5, 6, 7)},
+		{"5/15 * * * *", true, bits(5, 20, 35, 50), bits(0, 1, 2, 3, 4, 5, 6, 7)},
+		{"1-10/3 * * * *", true, bits(1, 4, 7, 10), bits(0, 1, 2, 3, 4, 5, 6, 7)},
+		{"0,30,59 * * * 1-5", true, bits(0, 30, 59), bits(1, 2, 3, 4, 5)},
+		{"0 * * * 7", true, bits(0), bits(0, 7)},
+		{"0 * * * 5/1", true, bits(0), bits(5, 6, 7, 0)},
+		{"60 * * * *", false, 0, 0},
+		{"* * 0 * *", false, 0, 0},
+		{"* * * 13 *", false, 0, 0},
+		{"* * * * 8", false, 0, 0},
+		{"5-1 * * * *", false, 0, 0},
+		{"*/0 * * * *", false, 0, 0},
+		{"a * * * *", false, 0, 0},
+		{"1, * * * *", false, 0, 0},
+		{"* * * *", false, 0, 0},
+		{"* * * * * *", false, 0, 0},
+	}
+
+	for _, c := range caseList {
+		cronSpec, ok := ParseCron(c.expr)
+		if c.ok != ok {
+			t.Errorf("ParseCron(%q) ok = %v, want %v", c.expr, ok, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if c.minute != cronSpec.Minute {
+			t.Errorf("ParseCron(%q) minute = %b, want %b", c.expr, cronSpec.Minute, c.minute)
+		}
+		if c.dow != cronSpec.Dow {
+			t.Errorf("ParseCron(%q) dow = %b, want %b", c.expr, cronSpec.Dow, c.dow)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if nil != err {
+		t.Skip("time zone database unavailable")
+	}
+	localLocation := time.Local
+	time.Local = location
+	defer func() {
+		time.Local = localLocation
+	}()
+
+	at := func(value string) int64 {
+		ret, err := time.ParseInLocation("2006-01-02 15:04 MST", value, location)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return ret.Unix()
+	}
+
+	caseList := []struct {
+		expr  string
+		after string
+		want  string
+	}{
+		{"5/15 * * * *", "2026-01-05 10:06 EST", "2026-01-05 10:20 EST"},
+		{"5/15 * * * *", "2026-01-05 10:50 EST", "2026-01-05 11:05 EST"},
+		{"0 9 * * *", "2026-01-05 09:00 EST", "2026-01-06 09:00 EST"},
+		{"0 0 1 * *", "2026-12-15 00:00 EST", "2027-01-01 00:00 EST"},
+		// either the day of month or the day of week matches if both are
+		// restricted, 2026-01-09 is a Friday.
+		{"0 12 13 * 5", "2026-01-05 00:00 EST", "2026-01-09 12:00 EST"},
+		{"0 12 13 * 5", "2026-01-10 00:00 EST", "2026-01-13 12:00 EST"},
+		{"0 12 13 * *", "2026-01-14 00:00 EST", "2026-02-13 12:00 EST"},
+		{"0 12 * * 5", "2026-01-10 00:00 EST", "2026-01-16 12:00 EST"},
+		{"0 12 * * 0", "2026-01-10 00:00 EST", "2026-01-11 12:00 EST"},
+		{"0 12 * * 7", "2026-01-10 00:00 EST", "2026-01-11 12:00 EST"},
+		{"0 0 29 2 *", "2026-03-01 00:00 EST", "2028-02-29 00:00 EST"},
+		// the skipped hour when clocks go forward never matches.
+		{"30 2 * * *", "2026-03-07 12:00 EST", "2026-03-09 02:30 EDT"},
+		{"0 * * * *", "2026-03-08 01:30 EST", "2026-03-08 03:00 EDT"},
+		// the repeated hour when clocks go back matches once, unless every
+		// hour matches.
+		{"30 1 * * *", "2026-10-31 12:00 EDT", "2026-11-01 01:30 EDT"},
+		{"30 1 * * *", "2026-11-01 01:30 EDT", "2026-11-02 01:30 EST"},
+		{"0 * * * *", "2026-11-01 01:00 EDT", "2026-11-01 01:00 EST"},
+		{"0 * * * *", "2026-11-01 01:00 EST", "2026-11-01 02:00 EST"},
+	}
+
+	for _, c := range caseList {
+		cronSpec, ok := ParseCron(c.expr)
+		if !ok {
+			t.Errorf("ParseCron(%q) failed", c.expr)
+			continue
+		}
+
+		if next := cronSpec.Next(at(c.after)); at(c.want) != next {
+			t.Errorf("%q Next(%s) = %s, want %s", c.expr, c.after, time.Unix(next, 0).In(location).Format("2006-01-02 15:04 MST"), c.want)
+		}
+	}
+
+	cronSpec, _ := ParseCron("0 0 31 2 *")
+	if next := cronSpec.Next(at("2026-01-01 00:00 EST")); 0 != next {
+		t.Errorf("Next of February 31 = %d, want 0", next)
+	}
+}
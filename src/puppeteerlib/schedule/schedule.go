@@ -0,0 +1,344 @@
+package schedule
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	mathrand "math/rand"
+	"os"
+	ppioutil "puppeteerlib/ioutil"
+	ppqueue "puppeteerlib/queue"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ID               = "ID"
+	URL              = "URL"
+	USER_AGENT       = "UserAgent"
+	INTERVAL         = "Interval"
+	CRON             = "Cron"
+	JITTER           = "Jitter"
+	DUE_TIME         = "DueTime"
+	NEXT_RUN         = "NextRun"
+	LAST_RUN         = "LastRun"
+	CREATE_TIME      = "CreateTime"
+	SCHEDULE_DIR     = "schedule"
+	HISTORY_PREFIX   = ".history"
+	HISTORY_MAX      = 100
+	INTERVAL_MIN     = int64(60)
+	ID_LEN           = 32
+	RESULT_QUEUED    = "queued"
+	RESULT_COALESCED = "coalesced"
+	RESULT_ERR       = "error"
+)
+
+// job properties kept by a schedule besides the render options.
+var JOB_CONTROL_LIST = []string{ppqueue.TIMEOUT, ppqueue.QUEUE, ppqueue.PRIORITY, ppqueue.CALLBACK_URL}
+
+// Schedule captures URL repeatedly, every Interval seconds or whenever
+// the Cron expression matches. Each run is delayed by a random number of
+// seconds below Jitter, DueTime is when the next run is due without the
+// jitter, NextRun with it.
+type Schedule struct {
+	ID         string
+	URL        string
+	UserAgent  string
+	Interval   int64
+	Cron       string
+	Jitter     int64
+	DueTime    int64
+	NextRun    int64
+	LastRun    int64
+	CreateTime int64
+	JobOptions map[string]string
+	JobControl map[string]string
+}
+
+// ScheduleRun is an entry of the history of a schedule, the key of the
+// job submitted at Time and what became of the submission.
+type ScheduleRun struct {
+	Time   int64
+	Key    string
+	Result string
+}
+
+func GetScheduleDir(queueDir string) string {
+	ret := queueDir + string(os.PathSeparator) + SCHEDULE_DIR
+	return ret
+}
+
+func getSchedulePath(queueDir string, id string) string {
+	return GetScheduleDir(queueDir) + string(os.PathSeparator) + id
+}
+
+func getHistoryPath(queueDir string, id string) string {
+	return getSchedulePath(queueDir, id) + HISTORY_PREFIX
+}
+
+// IsValidID tells whether id looks like an id returned by NewSchedule, so
+// that it is safe to be used as a file name.
+func IsValidID(id string) bool {
+	if ID_LEN != len(id) {
+		return false
+	}
+
+	for _, ch := range id {
+		if !(('a' <= ch && 'f' >= ch) || ('0' <= ch && '9' >= ch)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewSchedule returns a schedule with a new id, due for its first run at
+// once for an interval schedule, or when the cron expression first
+// matches. It returns nil if neither or both of interval and cronExpr are
+// given, or if they are invalid.
+func NewSchedule(targetURL string, userAgent string, interval int64, cronExpr string, jitter int64) *Schedule {
+	if (0 == interval) == ("" == cronExpr) || 0 > jitter {
+		return nil
+	}
+
+	if 0 != interval && INTERVAL_MIN > interval {
+		return nil
+	}
+
+	if "" != cronExpr {
+		if _, ok := ParseCron(cronExpr); !ok {
+			return nil
+		}
+	}
+
+	idBytes := make([]byte, ID_LEN/2)
+	if _, err := rand.Read(idBytes); nil != err {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	ret := &Schedule{ID: fmt.Sprintf("%x", idBytes),
+		URL:        targetURL,
+		UserAgent:  userAgent,
+		Interval:   interval,
+		Cron:       strings.Join(strings.Fields(cronExpr), " "),
+		Jitter:     jitter,
+		CreateTime: now,
+		JobOptions: map[string]string{},
+		JobControl: map[string]string{}}
+
+	ret.DueTime = now
+	if "" != ret.Cron {
+		ret.DueTime = ret.getNextDue(now)
+	}
+	ret.NextRun = ret.DueTime + ret.getJitter()
+
+	return ret
+}
+
+func (this *Schedule) getJitter() int64 {
+	if 0 >= this.Jitter {
+		return 0
+	}
+
+	return mathrand.Int63n(this.Jitter)
+}
+
+// getNextDue returns the first due time after now, missed runs are
+// skipped rather than made up for.
+func (this *Schedule) getNextDue(now int64) int64 {
+	if "" != this.Cron {
+		if cronSpec, ok := ParseCron(this.Cron); ok {
+			return cronSpec.Next(now)
+		}
+		return 0
+	}
+
+	if this.DueTime > now {
+		return this.DueTime
+	}
+
+	return this.DueTime + ((now-this.DueTime)/this.Interval+1)*this.Interval
+}
+
+// Advance records a run at now and plans the next one.
+func (this *Schedule) Advance(now int64) {
+	this.LastRun = now
+	this.DueTime = this.getNextDue(now)
+	this.NextRun = 0
+	if 0 < this.DueTime {
+		this.NextRun = this.DueTime + this.getJitter()
+	}
+}
+
+// IsDue tells whether the schedule should run at now. A schedule whose
+// cron expression never matches again is never due.
+func (this *Schedule) IsDue(now int64) bool {
+	return 0 < this.NextRun && this.NextRun <= now
+}
+
+func WriteSchedule(queueDir string, schedule *Schedule) bool {
+	scheduleInfo := map[string]string{ID: schedule.ID,
+		URL:         schedule.URL,
+		USER_AGENT:  schedule.UserAgent,
+		INTERVAL:    strconv.FormatInt(schedule.Interval, 10),
+		CRON:        schedule.Cron,
+		JITTER:      strconv.FormatInt(schedule.Jitter, 10),
+		DUE_TIME:    strconv.FormatInt(schedule.DueTime, 10),
+		NEXT_RUN:    strconv.FormatInt(schedule.NextRun, 10),
+		LAST_RUN:    strconv.FormatInt(schedule.LastRun, 10),
+		CREATE_TIME: strconv.FormatInt(schedule.CreateTime, 10)}
+	for optName, optVal := range schedule.JobOptions {
+		scheduleInfo[optName] = optVal
+	}
+	for ctrlName, ctrlVal := range schedule.JobControl {
+		scheduleInfo[ctrlName] = ctrlVal
+	}
+
+	return ppioutil.WriteIni(getSchedulePath(queueDir, schedule.ID), scheduleInfo)
+}
+
+// UpdateSchedule writes back a schedule read before, unless it was removed
+// in the meantime.
+func UpdateSchedule(queueDir string, schedule *Schedule) bool {
+	if _, err := os.Stat(getSchedulePath(queueDir, schedule.ID)); nil != err {
+		return false
+	}
+
+	return WriteSchedule(queueDir, schedule)
+}
+
+// ReadSchedule returns the schedule of id, or nil if there is none.
+func ReadSchedule(queueDir string, id string) *Schedule {
+	if !IsValidID(id) {
+		return nil
+	}
+
+	scheduleInfo, err := ppioutil.ParseIni(getSchedulePath(queueDir, id))
+	if nil != err || nil == scheduleInfo || id != scheduleInfo[ID] {
+		return nil
+	}
+
+	ret := &Schedule{ID: id,
+		URL:        scheduleInfo[URL],
+		UserAgent:  scheduleInfo[USER_AGENT],
+		Cron:       scheduleInfo[CRON],
+		JobOptions: map[string]string{},
+		JobControl: map[string]string{}}
+	ret.Interval, _ = strconv.ParseInt(scheduleInfo[INTERVAL], 10, 64)
+	ret.Jitter, _ = strconv.ParseInt(scheduleInfo[JITTER], 10, 64)
+	ret.DueTime, _ = strconv.ParseInt(scheduleInfo[DUE_TIME], 10, 64)
+	ret.NextRun, _ = strconv.ParseInt(scheduleInfo[NEXT_RUN], 10, 64)
+	ret.LastRun, _ = strconv.ParseInt(scheduleInfo[LAST_RUN], 10, 64)
+	ret.CreateTime, _ = strconv.ParseInt(scheduleInfo[CREATE_TIME], 10, 64)
+
+	if "" == ret.Cron && 0 >= ret.Interval {
+		return nil
+	}
+
+	for _, optName := range ppqueue.RENDER_OPTION_LIST {
+		if optVal, optOk := scheduleInfo[optName]; optOk && "" != optVal {
+			ret.JobOptions[optName] = optVal
+		}
+	}
+	for _, ctrlName := range JOB_CONTROL_LIST {
+		if ctrlVal, ctrlOk := scheduleInfo[ctrlName]; ctrlOk && "" != ctrlVal {
+			ret.JobControl[ctrlName] = ctrlVal
+		}
+	}
+
+	return ret
+}
+
+// ListSchedules returns all the schedules kept in the queue dir.
+func ListSchedules(queueDir string) []*Schedule {
+	ret := make([]*Schedule, 0)
+
+	for _, fileName := range ppqueue.ListJobDir(GetScheduleDir(queueDir)) {
+		if !IsValidID(fileName) {
+			continue
+		}
+
+		if schedule := ReadSchedule(queueDir, fileName); nil != schedule {
+			ret = append(ret, schedule)
+		}
+	}
+
+	return ret
+}
+
+// RemoveSchedule removes the schedule of id along with its history, and
+// returns false if there is no such schedule.
+func RemoveSchedule(queueDir string, id string) bool {
+	if !IsValidID(id) {
+		return false
+	}
+
+	if err := os.Remove(getSchedulePath(queueDir, id)); nil != err {
+		return false
+	}
+	os.Remove(getHistoryPath(queueDir, id))
+
+	return true
+}
+
+// AppendHistory records a run of the schedule of id, only the latest
+// HISTORY_MAX runs are kept.
+func AppendHistory(queueDir string, id string, run ScheduleRun) bool {
+	historyPath := getHistoryPath(queueDir, id)
+	runList := append(ReadHistory(queueDir, id), run)
+	if HISTORY_MAX < len(runList) {
+		runList = runList[len(runList)-HISTORY_MAX:]
+	}
+
+	fileHandle, err := os.OpenFile(historyPath+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, ppioutil.FILE_MASK)
+	if nil != err {
+		return false
+	}
+
+	writer := bufio.NewWriter(fileHandle)
+	for _, historyRun := range runList {
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", historyRun.Time, historyRun.Key, historyRun.Result)
+	}
+	err = writer.Flush()
+	fileHandle.Close()
+
+	if nil == err {
+		err = os.Rename(historyPath+".tmp", historyPath)
+	}
+
+	if nil != err {
+		os.Remove(historyPath + ".tmp")
+		return false
+	}
+
+	return true
+}
+
+// ReadHistory returns the runs of the schedule of id, oldest first.
+func ReadHistory(queueDir string, id string) []ScheduleRun {
+	ret := make([]ScheduleRun, 0)
+
+	fileHandle, err := os.Open(getHistoryPath(queueDir, id))
+	if nil != err {
+		return ret
+	}
+	defer fileHandle.Close()
+
+	scanner := bufio.NewScanner(fileHandle)
+	for scanner.Scan() {
+		partList := strings.Split(scanner.Text(), "\t")
+		if 3 != len(partList) {
+			continue
+		}
+
+		runTime, err := strconv.ParseInt(partList[0], 10, 64)
+		if nil != err {
+			continue
+		}
+		ret = append(ret, ScheduleRun{Time: runTime, Key: partList[1], Result: partList[2]})
+	}
+
+	return ret
+}
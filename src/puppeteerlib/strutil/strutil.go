@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	neturl "net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -27,6 +29,17 @@ func IsValidURL(url string) bool {
 	return true
 }
 
+// URL2Host returns the lower cased host name of url without port, or an
+// empty string if url can not be parsed.
+func URL2Host(rawURL string) string {
+	urlInfo, err := neturl.Parse(rawURL)
+	if nil != err {
+		return ""
+	}
+
+	return strings.ToLower(urlInfo.Hostname())
+}
+
 func URL2Fingerprint(url string) string {
 	hashHandle := md5.New()
 	io.WriteString(hashHandle, url)
@@ -36,6 +49,34 @@ func URL2Fingerprint(url string) string {
 	return ret
 }
 
+// URLOptions2Fingerprint works like URL2Fingerprint, but mixes the given
+// job options into the hash so that renderings of the same url with
+// different options get different fingerprints. Empty options are ignored,
+// thus a url without options keeps its original fingerprint.
+func URLOptions2Fingerprint(url string, options map[string]string) string {
+	nameList := make([]string, 0, len(options))
+	for name, val := range options {
+		if "" != val {
+			nameList = append(nameList, name)
+		}
+	}
+
+	if 0 == len(nameList) {
+		return URL2Fingerprint(url)
+	}
+	sort.Strings(nameList)
+
+	hashHandle := md5.New()
+	io.WriteString(hashHandle, url)
+	for _, name := range nameList {
+		io.WriteString(hashHandle, "\n"+name+"="+options[name])
+	}
+	md5Hash := fmt.Sprintf("%x", hashHandle.Sum(nil))
+	ret := md5Hash + "." + strconv.Itoa(len(url))
+
+	return ret
+}
+
 func GetRandomString(length uint16) string {
 	charList := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z",
 		"A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M", "N", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z",